@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// AutoCertificateID is the certificateID argument asking createCertificate
+// and createPrivateCertificate to allocate the ID
+const AutoCertificateID = "auto"
+
+// MaxAllocation limits the IDs reserved by one allocateCertificateID call
+const MaxAllocation = 100
+
+// LegacySharedSequence is the ID sequence once shared by the authorities
+// without ID ranges. Every authority now has a sequence of its own, so
+// issuers of different authorities never conflict on it. The new sequence of
+// an authority without ID ranges starts where the shared one ended.
+const LegacySharedSequence = "shared"
+
+// idSequence is the last ID allocated from a sequence
+type idSequence struct {
+	Last int `json:"Last"`
+}
+
+// Reservation is a certificate ID allocated to an authority that has not
+// been used yet
+type Reservation struct {
+	CertificateID string `json:"CertificateID"`
+	AuthorityID   string `json:"AuthorityID"`
+	ReservedAt    string `json:"ReservedAt"`
+}
+
+// ============================================================
+// allocateCertificateID - reserve certificate IDs for the
+// invoker's authority. IDs end in a Luhn check digit and come
+// from the ID ranges of the authority, or from any free ID if it
+// has none. Reserving IDs ahead lets issuers of
+// one authority create certificates concurrently.
+// args: [count [, category]]
+// ============================================================
+func allocateCertificateID(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) > 2 {
+		return shim.Error("!! Incorrect number of arguments, Expecting 0 to 2 !!")
+	}
+	count := 1
+	if len(args) >= 1 {
+		var err error
+		if count, err = strconv.Atoi(args[0]); err != nil || count < 1 || count > MaxAllocation {
+			return shim.Error(fmt.Sprintf("1st argument count must be a number from 1 to %d", MaxAllocation))
+		}
+	}
+	category := ""
+	if len(args) == 2 {
+		category = args[1]
+	}
+
+	fmt.Println("- start allocateCertificateID -")
+	authority, err := allocatingAuthority(stub, category)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	ids, err := allocateIDs(stub, authority, count)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	reservedAt, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, id := range ids {
+		reservation := &Reservation{CertificateID: id, AuthorityID: authority.AuthorityID, ReservedAt: reservedAt}
+		reservationKey, err := stub.CreateCompositeKey("idreservation", []string{id})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		reservationAsBytes, err := json.Marshal(reservation)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err = stub.PutState(reservationKey, reservationAsBytes); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	idsAsBytes, err := json.Marshal(ids)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end allocateCertificateID: %s\n", idsAsBytes)
+	return shim.Success(idsAsBytes)
+}
+
+// autoCertificateID allocates the ID of a certificate created with "auto"
+func autoCertificateID(stub shim.ChaincodeStubInterface, category string) (string, error) {
+	authority, err := allocatingAuthority(stub, category)
+	if err != nil {
+		return "", err
+	}
+	ids, err := allocateIDs(stub, authority, 1)
+	if err != nil {
+		return "", err
+	}
+	return ids[0], nil
+}
+
+// allocatingAuthority picks the first active authority of the invoker that
+// may write certificates of a category
+func allocatingAuthority(stub shim.ChaincodeStubInterface, category string) (*Authority, error) {
+	authorities, err := invokerAuthorities(stub)
+	if err != nil {
+		return nil, err
+	}
+	if len(authorities) == 0 {
+		return nil, fmt.Errorf("Invoker is not a registered authority")
+	}
+	for _, authority := range authorities {
+		if authority.Status == AuthorityActive && authority.permitsCategory(category) {
+			return authority, nil
+		}
+	}
+	return nil, fmt.Errorf("Invoker may not write certificates of category %q", category)
+}
+
+// allocateIDs takes the next count free IDs from the sequence of an authority
+func allocateIDs(stub shim.ChaincodeStubInterface, authority *Authority, count int) ([]string, error) {
+	sequenceKey, err := stub.CreateCompositeKey("idsequence", []string{authority.AuthorityID})
+	if err != nil {
+		return nil, err
+	}
+	sequence, err := getIDSequence(stub, sequenceKey)
+	if err != nil {
+		return nil, err
+	}
+	if sequence == nil && len(authority.IDRanges) == 0 {
+		legacyKey, err := stub.CreateCompositeKey("idsequence", []string{LegacySharedSequence})
+		if err != nil {
+			return nil, err
+		}
+		if sequence, err = getIDSequence(stub, legacyKey); err != nil {
+			return nil, err
+		}
+	}
+	if sequence == nil {
+		sequence = &idSequence{}
+	}
+
+	ids := []string{}
+	for len(ids) < count {
+		if sequence.Last, err = nextCheckedID(sequence.Last, authority); err != nil {
+			return nil, err
+		}
+		// IDs chosen by callers or reserved before are skipped
+		id := strconv.Itoa(sequence.Last)
+		taken, err := certificateIDTaken(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		if !taken {
+			ids = append(ids, id)
+		}
+	}
+
+	sequenceAsBytes, err := json.Marshal(sequence)
+	if err != nil {
+		return nil, err
+	}
+	if err = stub.PutState(sequenceKey, sequenceAsBytes); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// getIDSequence reads an ID sequence, nil if it was never used
+func getIDSequence(stub shim.ChaincodeStubInterface, sequenceKey string) (*idSequence, error) {
+	sequenceAsBytes, err := stub.GetState(sequenceKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get ID sequence: %s", err)
+	} else if sequenceAsBytes == nil {
+		return nil, nil
+	}
+	sequence := &idSequence{}
+	if err = json.Unmarshal(sequenceAsBytes, sequence); err != nil {
+		return nil, err
+	}
+	return sequence, nil
+}
+
+// nextCheckedID is the first ID after an ID that ends in the Luhn check
+// digit of its other digits and falls in the ID ranges of the authority
+func nextCheckedID(after int, authority *Authority) (int, error) {
+	for payload := after/10 + 1; ; payload++ {
+		id := payload*10 + luhnCheckDigit(payload)
+		if id <= after {
+			continue
+		}
+		if len(authority.IDRanges) == 0 || authority.inRange(id) {
+			return id, nil
+		}
+
+		// skip to the next range
+		next := -1
+		for _, idRange := range authority.IDRanges {
+			if idRange.From > id && (next < 0 || idRange.From < next) {
+				next = idRange.From
+			}
+		}
+		if next < 0 {
+			return 0, fmt.Errorf("ID ranges of authority %s are exhausted", authority.AuthorityID)
+		}
+		if next/10-1 > payload {
+			payload = next/10 - 1
+		}
+	}
+}
+
+// luhnCheckDigit is the Luhn check digit of a number
+func luhnCheckDigit(payload int) int {
+	sum := 0
+	double := true
+	for n := payload; n > 0; n /= 10 {
+		digit := n % 10
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return (10 - sum%10) % 10
+}
+
+// certificateIDTaken reports whether a certificate ID is used or reserved
+func certificateIDTaken(stub shim.ChaincodeStubInterface, certificateID string) (bool, error) {
+	certificateRecordAsBytes, err := stub.GetState(certificateID)
+	if err != nil {
+		return false, fmt.Errorf("Failed to get certificate record: %s", err)
+	} else if certificateRecordAsBytes != nil {
+		return true, nil
+	}
+	reservation, err := getReservation(stub, certificateID)
+	return reservation != nil, err
+}
+
+// useReservation releases the reservation of a certificate ID being created,
+// failing if another authority holds it
+func useReservation(stub shim.ChaincodeStubInterface, certificateID string, authority *Authority) error {
+	reservation, err := getReservation(stub, certificateID)
+	if err != nil || reservation == nil {
+		return err
+	}
+	if reservation.AuthorityID != authority.AuthorityID {
+		return fmt.Errorf("Certificate ID %s is reserved by another authority", certificateID)
+	}
+	reservationKey, err := stub.CreateCompositeKey("idreservation", []string{certificateID})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(reservationKey)
+}
+
+// getReservation reads the reservation of an ID, returning nil if it has none
+func getReservation(stub shim.ChaincodeStubInterface, certificateID string) (*Reservation, error) {
+	reservationKey, err := stub.CreateCompositeKey("idreservation", []string{certificateID})
+	if err != nil {
+		return nil, err
+	}
+	reservationAsBytes, err := stub.GetState(reservationKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get reservation: %s", err)
+	} else if reservationAsBytes == nil {
+		return nil, nil
+	}
+	reservation := &Reservation{}
+	if err = json.Unmarshal(reservationAsBytes, reservation); err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/bluezd/BlockChain/SocialSecurity/merkle"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// MaxAnchorBatchSize limits the number of leaves of a single anchorBatch call
+const MaxAnchorBatchSize = 10000
+
+// AnchorLeaf is a single certificate anchored in a batch
+type AnchorLeaf struct {
+	CertificateID   string `json:"CertificateID"`
+	CertificateHash string `json:"CertificateHash"`
+}
+
+// AnchorBatch is a Merkle tree of certificate hashes anchored in one
+// transaction, keyed by the transaction ID. The leaves are kept one by one
+// with their proof, see anchorEntry.
+type AnchorBatch struct {
+	BatchID    string `json:"BatchID"`
+	Root       string `json:"Root"` // hex encoded Merkle root
+	Size       int    `json:"Size"`
+	AnchoredAt string `json:"AnchoredAt"` // RFC 3339 transaction timestamp
+}
+
+// anchorEntry is a certificate leaf of an anchored batch, keyed by the
+// certificate and batch IDs, with its sibling path so the proof is read
+// without the other leaves
+type anchorEntry struct {
+	Index           int      `json:"Index"`
+	CertificateHash string   `json:"CertificateHash"`
+	Path            []string `json:"Path"` // hex encoded sibling hashes, from the leaf up
+}
+
+// InclusionResult is the response of verifyInclusion
+type InclusionResult struct {
+	Valid      bool   `json:"Valid"`
+	BatchID    string `json:"BatchID"`
+	Root       string `json:"Root"`
+	AnchoredAt string `json:"AnchoredAt"`
+	Reason     string `json:"Reason,omitempty"`
+}
+
+// ============================================================
+// anchorBatch - anchor many certificate hashes at once as the
+// Merkle root of a JSON list of {CertificateID, CertificateHash}
+// ============================================================
+func anchorBatch(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("!! Incorrect number of arguments, Expecting 1 !!")
+	}
+
+	fmt.Println("- start anchorBatch -")
+	leaves := []AnchorLeaf{}
+	if err := json.Unmarshal([]byte(args[0]), &leaves); err != nil {
+		return shim.Error("1st argument must be a JSON list of leaves: " + err.Error())
+	}
+	if len(leaves) == 0 {
+		return shim.Error("1st argument must hold at least one leaf")
+	}
+	if len(leaves) > MaxAnchorBatchSize {
+		return shim.Error(fmt.Sprintf("A batch holds at most %d leaves", MaxAnchorBatchSize))
+	}
+
+	authorities, err := invokerAuthorities(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	leafData := make([][]byte, len(leaves))
+	seen := map[string]bool{}
+	for i, leaf := range leaves {
+		if _, err := strconv.Atoi(leaf.CertificateID); err != nil {
+			return shim.Error(fmt.Sprintf("leaf %d: certificateID should be a vaild numeric string", i))
+		}
+		if len(leaf.CertificateHash) <= 0 {
+			return shim.Error(fmt.Sprintf("leaf %d: certificateHash must be a non-empty string", i))
+		}
+		// a stored certificate is checked with its category
+		category, err := certificateCategory(stub, leaf.CertificateID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if _, err = authorityFor(authorities, leaf.CertificateID, category); err != nil {
+			return shim.Error(fmt.Sprintf("leaf %d: %s", i, err))
+		}
+		if seen[leaf.CertificateID] {
+			return shim.Error("Duplicate certificateID in batch: " + leaf.CertificateID)
+		}
+		seen[leaf.CertificateID] = true
+		leafData[i] = merkle.Leaf(leaf.CertificateID, leaf.CertificateHash)
+	}
+
+	anchoredAt, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	root, paths := merkle.Paths(leafData)
+	batch := &AnchorBatch{
+		BatchID:    stub.GetTxID(),
+		Root:       hex.EncodeToString(root),
+		Size:       len(leaves),
+		AnchoredAt: anchoredAt,
+	}
+
+	batchKey, err := stub.CreateCompositeKey("anchor~batch", []string{batch.BatchID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	batchAsBytes, err := json.Marshal(batch)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = stub.PutState(batchKey, batchAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// every leaf with its path, to find its proof by ID
+	for i, leaf := range leaves {
+		entryKey, err := stub.CreateCompositeKey("anchor~id", []string{leaf.CertificateID, batch.BatchID})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		entry := anchorEntry{Index: i, CertificateHash: leaf.CertificateHash, Path: make([]string, len(paths[i]))}
+		for j, sibling := range paths[i] {
+			entry.Path[j] = hex.EncodeToString(sibling)
+		}
+		entryAsBytes, _ := json.Marshal(entry)
+		if err = stub.PutState(entryKey, entryAsBytes); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	fmt.Printf("- end anchorBatch: %s\n", batchAsBytes)
+	return shim.Success(batchAsBytes)
+}
+
+// ============================================================
+// getInclusionProof - the Merkle proof of an anchored
+// certificate, from its latest batch unless a batch is given.
+// The proof carries the certificate hash, so it is limited to
+// the readers of the certificate, see reader.
+// args: certificateID [, batchID]
+// ============================================================
+func getInclusionProof(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("!! Incorrect number of arguments, Expecting 1 or 2 !!")
+	}
+
+	r, err := newReader(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	certificateRecordAsBytes, err := stub.GetState(args[0])
+	if err != nil {
+		return shim.Error("Failed to get certificate record: " + err.Error())
+	}
+	var ok bool
+	if certificateRecordAsBytes != nil {
+		ok, err = r.canReadBytes(certificateRecordAsBytes)
+	} else {
+		// a removed certificate is left to admins and the authorities of its ID
+		ok, err = r.canRead(&Certificate{CertificateID: args[0]})
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if !ok {
+		return shim.Error("Access denied to " + args[0])
+	}
+
+	batchID := ""
+	if len(args) == 2 {
+		batchID = args[1]
+	}
+
+	proof, _, err := inclusionProof(stub, args[0], batchID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	proofAsBytes, err := json.Marshal(proof)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(proofAsBytes)
+}
+
+// ============================================================
+// verifyInclusion - check a certificate hash against an
+// anchored root, either from a proof returned by
+// getInclusionProof or from a certificateID and hash
+// args: proof | certificateID, certificateHash
+// ============================================================
+func verifyInclusion(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var proof *merkle.Proof
+	var batch *AnchorBatch
+	var err error
+
+	result := InclusionResult{}
+	if len(args) == 1 {
+		proof = &merkle.Proof{}
+		if err = json.Unmarshal([]byte(args[0]), proof); err != nil {
+			return shim.Error("1st argument must be a JSON inclusion proof: " + err.Error())
+		}
+		if batch, err = getAnchorBatch(stub, proof.BatchID); err != nil {
+			return shim.Error(err.Error())
+		}
+		if batch == nil {
+			result.Reason = "batch is not anchored"
+		}
+	} else if len(args) == 2 {
+		if proof, batch, err = inclusionProof(stub, args[0], ""); err != nil {
+			return shim.Error(err.Error())
+		}
+		// the proof is built from the anchored leaf, check the given hash instead
+		proof.CertificateHash = args[1]
+	} else {
+		return shim.Error("!! Incorrect number of arguments, Expecting 1 or 2 !!")
+	}
+
+	if batch != nil {
+		result.BatchID = batch.BatchID
+		result.Root = batch.Root
+		result.AnchoredAt = batch.AnchoredAt
+		// only the anchored root counts, never the one carried by the proof
+		if err = proof.Verify(batch.Root); err != nil {
+			result.Reason = err.Error()
+		} else {
+			result.Valid = true
+		}
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
+
+// inclusionProof builds the proof of a certificate in an anchored batch, the
+// latest one holding the certificate if batchID is empty
+func inclusionProof(stub shim.ChaincodeStubInterface, certificateID string, batchID string) (*merkle.Proof, *AnchorBatch, error) {
+	var batch *AnchorBatch
+	var entry anchorEntry
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("anchor~id", []string{certificateID})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if batchID != "" && compositeKeyParts[1] != batchID {
+			continue
+		}
+
+		candidate, err := getAnchorBatch(stub, compositeKeyParts[1])
+		if err != nil {
+			return nil, nil, err
+		}
+		// RFC 3339 timestamps in UTC sort chronologically
+		if candidate == nil || (batch != nil && candidate.AnchoredAt <= batch.AnchoredAt) {
+			continue
+		}
+		candidateEntry := anchorEntry{}
+		if err = json.Unmarshal(responseRange.Value, &candidateEntry); err != nil {
+			return nil, nil, err
+		}
+		batch, entry = candidate, candidateEntry
+	}
+	if batch == nil {
+		return nil, nil, fmt.Errorf("Certificate is not anchored: %s", certificateID)
+	}
+
+	proof := &merkle.Proof{
+		CertificateID:   certificateID,
+		CertificateHash: entry.CertificateHash,
+		BatchID:         batch.BatchID,
+		Index:           uint64(entry.Index),
+		Size:            uint64(batch.Size),
+		Path:            entry.Path,
+		Root:            batch.Root,
+	}
+	return proof, batch, nil
+}
+
+// getAnchorBatch reads an anchored batch, returning nil if it does not exist
+func getAnchorBatch(stub shim.ChaincodeStubInterface, batchID string) (*AnchorBatch, error) {
+	batchKey, err := stub.CreateCompositeKey("anchor~batch", []string{batchID})
+	if err != nil {
+		return nil, err
+	}
+	batchAsBytes, err := stub.GetState(batchKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get anchored batch: %s", err)
+	} else if batchAsBytes == nil {
+		return nil, nil
+	}
+	batch := &AnchorBatch{}
+	if err = json.Unmarshal(batchAsBytes, batch); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
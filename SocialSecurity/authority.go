@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// AuthorityObjectType is the composite key object type of authority records,
+// which keeps them out of the range scans of certificate records
+const AuthorityObjectType = "authority"
+
+// Authority states
+const (
+	AuthorityActive    = "active"
+	AuthoritySuspended = "suspended"
+)
+
+// AdminRole is the value of the "role" attribute in the enrollment
+// certificate of clients of the admin MSP allowed to manage the authority
+// registry, see Init
+const AdminRole = "admin"
+
+// IDRange is an inclusive range of certificate IDs
+type IDRange struct {
+	From int `json:"From"`
+	To   int `json:"To"`
+}
+
+// Authority is an issuing authority. An invoker acts as the authority when
+// it belongs to MSPID and carries every attribute in Attributes. It may
+// write certificates whose ID falls in one of IDRanges, if any are given,
+// and whose Category is one of Categories, if any are given.
+type Authority struct {
+	AuthorityID  string            `json:"AuthorityID"`
+	Name         string            `json:"Name"`
+	MSPID        string            `json:"MSPID"`
+	Attributes   map[string]string `json:"Attributes,omitempty"`
+	IDRanges     []IDRange         `json:"IDRanges,omitempty"`
+	Categories   []string          `json:"Categories,omitempty"`
+	Status       string            `json:"Status"`
+	RegisteredAt string            `json:"RegisteredAt"`
+	SuspendedAt  string            `json:"SuspendedAt,omitempty"`
+}
+
+// ============================================================
+// registerAuthority - add an issuing authority, or replace and
+// reinstate an existing one. Admin only.
+// args: Authority JSON
+// ============================================================
+func registerAuthority(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("!! Incorrect number of arguments, Expecting 1 !!")
+	}
+	if !isAdmin(stub) {
+		return shim.Error("Only an admin can register an authority")
+	}
+
+	fmt.Println("- start registerAuthority -")
+	authority := &Authority{}
+	if err := json.Unmarshal([]byte(args[0]), authority); err != nil {
+		return shim.Error("1st argument must be an authority JSON: " + err.Error())
+	}
+	if len(authority.AuthorityID) <= 0 {
+		return shim.Error("AuthorityID must be a non-empty string")
+	}
+	if len(authority.MSPID) <= 0 {
+		return shim.Error("MSPID must be a non-empty string")
+	}
+	if len(authority.IDRanges) == 0 && len(authority.Categories) == 0 {
+		return shim.Error("An authority needs at least one ID range or category")
+	}
+	for _, idRange := range authority.IDRanges {
+		if idRange.From < 0 || idRange.From > idRange.To {
+			return shim.Error(fmt.Sprintf("Invalid ID range %d-%d", idRange.From, idRange.To))
+		}
+	}
+
+	registeredAt, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	authority.Status = AuthorityActive
+	authority.RegisteredAt = registeredAt
+	authority.SuspendedAt = ""
+
+	if err = putAuthority(stub, authority); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end registerAuthority -")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// suspendAuthority - stop an authority from writing
+// certificates, registerAuthority reinstates it. Admin only.
+// args: authorityID
+// ============================================================
+func suspendAuthority(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("!! Incorrect number of arguments, Expecting 1 !!")
+	}
+	if !isAdmin(stub) {
+		return shim.Error("Only an admin can suspend an authority")
+	}
+
+	fmt.Println("- start suspendAuthority -")
+	authority, err := getAuthority(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if authority == nil {
+		return shim.Error("Authority does not exist: " + args[0])
+	}
+	if authority.Status == AuthoritySuspended {
+		return shim.Error("Authority is already suspended: " + args[0])
+	}
+
+	if authority.SuspendedAt, err = txTime(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+	authority.Status = AuthoritySuspended
+	if err = putAuthority(stub, authority); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end suspendAuthority -")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// queryAuthority - read an authority
+// args: authorityID
+// ============================================================
+func queryAuthority(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("!! Incorrect number of arguments, Expecting 1 !!")
+	}
+
+	authority, err := getAuthority(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if authority == nil {
+		return shim.Error("Authority does not exist: " + args[0])
+	}
+	authorityAsBytes, err := json.Marshal(authority)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(authorityAsBytes)
+}
+
+// permits reports whether the authority may write the certificate
+func (a *Authority) permits(certificateID string, category string) bool {
+	if a.Status != AuthorityActive || !a.permitsCategory(category) {
+		return false
+	}
+	if len(a.IDRanges) == 0 {
+		return true
+	}
+	id, err := strconv.Atoi(certificateID)
+	return err == nil && a.inRange(id)
+}
+
+// permitsCategory reports whether the authority may write certificates of a
+// category
+func (a *Authority) permitsCategory(category string) bool {
+	if len(a.Categories) == 0 {
+		return true
+	}
+	for _, c := range a.Categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// inRange reports whether an ID falls in one of the ranges of the authority
+func (a *Authority) inRange(id int) bool {
+	for _, idRange := range a.IDRanges {
+		if id >= idRange.From && id <= idRange.To {
+			return true
+		}
+	}
+	return false
+}
+
+// invokerAuthorities returns the authorities the invoker acts as, suspended
+// ones included
+func invokerAuthorities(stub shim.ChaincodeStubInterface) ([]*Authority, error) {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get invoker MSP ID: %s", err)
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(AuthorityObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	authorities := []*Authority{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		authority := &Authority{}
+		if err = json.Unmarshal(responseRange.Value, authority); err != nil {
+			return nil, err
+		}
+		if authority.MSPID != mspID {
+			continue
+		}
+		matches := true
+		for name, value := range authority.Attributes {
+			if found, ok, err := cid.GetAttributeValue(stub, name); err != nil || !ok || found != value {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			authorities = append(authorities, authority)
+		}
+	}
+	return authorities, nil
+}
+
+// authorizeInvoker returns the authority the invoker writes a certificate as
+func authorizeInvoker(stub shim.ChaincodeStubInterface, certificateID string, category string) (*Authority, error) {
+	authorities, err := invokerAuthorities(stub)
+	if err != nil {
+		return nil, err
+	}
+	return authorityFor(authorities, certificateID, category)
+}
+
+// authorityFor picks the first of the invoker's authorities permitted to
+// write the certificate
+func authorityFor(authorities []*Authority, certificateID string, category string) (*Authority, error) {
+	if len(authorities) == 0 {
+		return nil, fmt.Errorf("Invoker is not a registered authority")
+	}
+	suspended := true
+	for _, authority := range authorities {
+		if authority.permits(certificateID, category) {
+			return authority, nil
+		}
+		suspended = suspended && authority.Status == AuthoritySuspended
+	}
+	if suspended {
+		return nil, fmt.Errorf("Authority %s is suspended", authorities[0].AuthorityID)
+	}
+	return nil, fmt.Errorf("Invoker may not write certificate %s", certificateID)
+}
+
+// certificateCategory is the Category of a stored certificate, or "" if the
+// certificate does not exist
+func certificateCategory(stub shim.ChaincodeStubInterface, certificateID string) (string, error) {
+	certificateRecordAsBytes, err := stub.GetState(certificateID)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get certificate record: %s", err)
+	} else if certificateRecordAsBytes == nil {
+		return "", nil
+	}
+	certificateRecord := &Certificate{}
+	if err = json.Unmarshal(certificateRecordAsBytes, certificateRecord); err != nil {
+		return "", err
+	}
+	return certificateRecord.Category, nil
+}
+
+// getAuthority reads an authority, returning nil if it does not exist
+func getAuthority(stub shim.ChaincodeStubInterface, authorityID string) (*Authority, error) {
+	authorityKey, err := stub.CreateCompositeKey(AuthorityObjectType, []string{authorityID})
+	if err != nil {
+		return nil, err
+	}
+	authorityAsBytes, err := stub.GetState(authorityKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get authority: %s", err)
+	} else if authorityAsBytes == nil {
+		return nil, nil
+	}
+	authority := &Authority{}
+	if err = json.Unmarshal(authorityAsBytes, authority); err != nil {
+		return nil, err
+	}
+	return authority, nil
+}
+
+func putAuthority(stub shim.ChaincodeStubInterface, authority *Authority) error {
+	authorityKey, err := stub.CreateCompositeKey(AuthorityObjectType, []string{authority.AuthorityID})
+	if err != nil {
+		return err
+	}
+	authorityAsBytes, err := json.Marshal(authority)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(authorityKey, authorityAsBytes)
+}
+
+// isAdmin reports whether the invoker belongs to the admin MSP stored by
+// Init and carries the role=admin attribute. Any other organization can
+// enroll clients with that attribute, so there is no admin until Init
+// names the admin MSP.
+func isAdmin(stub shim.ChaincodeStubInterface) bool {
+	config, err := getConfig(stub)
+	if err != nil || config.AdminMSPID == "" {
+		return false
+	}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil || mspID != config.AdminMSPID {
+		return false
+	}
+	role, found, err := cid.GetAttributeValue(stub, "role")
+	return err == nil && found && role == AdminRole
+}
+
+// txTime is the transaction timestamp in RFC 3339
+func txTime(stub shim.ChaincodeStubInterface) (string, error) {
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return "", err
+	}
+	return time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC().Format(time.RFC3339), nil
+}
@@ -24,6 +24,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
@@ -34,16 +35,123 @@ type CertificateChaincode struct {
 }
 
 type Certificate struct {
-	CertificateID   string `json:"CertificateID"`
-	CertificateHash string `json:"CertificateHash"`
+	CertificateID    string `json:"CertificateID"`
+	CertificateHash  string `json:"CertificateHash"`
+	Version          int    `json:"Version,omitempty"`      // 1 on create, records written before versioning have none
+	Reason           string `json:"Reason,omitempty"`       // reason code of the update that wrote this version
+	PreviousHash     string `json:"PreviousHash,omitempty"` // CertificateHash of the previous version
+	ChainLink        string `json:"ChainLink,omitempty"`    // chainLink of the previous version
+	Category         string `json:"Category,omitempty"`
+	IssuingAuthority string `json:"IssuingAuthority,omitempty"` // AuthorityID of the authority that wrote this version
+	Holder           string `json:"Holder,omitempty"`           // client identity (cid.GetID) of the individual
+	Commitment       string `json:"Commitment,omitempty"`       // salted commitment of a private certificate, which has no CertificateHash
+	IssueDate        string `json:"IssueDate,omitempty"`        // RFC 3339 transaction timestamp of the creation
+	ValidFrom        string `json:"ValidFrom,omitempty"`        // RFC 3339
+	ValidUntil       string `json:"ValidUntil,omitempty"`       // RFC 3339, empty if the certificate does not expire
+	Status           string `json:"Status,omitempty"`           // active, suspended, revoked or expired
+	ContentURI       string `json:"ContentURI,omitempty"`       // where the document of CertificateHash can be fetched
+	MediaType        string `json:"MediaType,omitempty"`
+}
+
+// digest is the value identifying the content of a record in the id~all index
+func (c *Certificate) digest() string {
+	if c.Commitment != "" {
+		return c.Commitment
+	}
+	return c.CertificateHash
+}
+
+// PrivateOnlyMode is the Init argument that turns on Config.PrivateOnly
+const PrivateOnlyMode = "private"
+
+// Config is the chaincode configuration stored by Init
+type Config struct {
+	AdminMSPID string `json:"AdminMSPID"` // MSP of the admins, see isAdmin
+	// PrivateOnly rejects public certificates, whose CertificateHash is
+	// written to the channel ledger in plaintext. Turn it on once the
+	// collection of collections_config.json is deployed.
+	PrivateOnly bool `json:"PrivateOnly,omitempty"`
+	// CollectionMSPIDs are the member organizations of the private
+	// collection, the same MSPs as in the policy of collections_config.json.
+	// Only their clients are given private certificate bodies, see
+	// withPrivateBody. Defaults to AdminMSPID.
+	CollectionMSPIDs []string `json:"CollectionMSPIDs,omitempty"`
 }
 
 // Init initializes chaincode
+// args: [adminMSPID [, mode [, collectionMSPIDs]]]
+// mode is "private" to turn on Config.PrivateOnly, or empty. collectionMSPIDs
+// is a comma separated list of the member MSPs of the private collection.
+// Without arguments, as on an upgrade that keeps the configuration, the
+// stored configuration is kept.
 // ===========================
 func (t *CertificateChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	if len(args) == 0 {
+		return shim.Success(nil)
+	}
+	if len(args) > 3 {
+		return shim.Error("!! Incorrect number of arguments, Expecting 0 to 3 !!")
+	}
+	if len(args[0]) <= 0 {
+		return shim.Error("1st argument adminMSPID must be a non-empty string")
+	}
+	config := &Config{AdminMSPID: args[0], CollectionMSPIDs: []string{args[0]}}
+	if len(args) >= 2 && args[1] != "" {
+		if args[1] != PrivateOnlyMode {
+			return shim.Error("2nd argument mode must be " + PrivateOnlyMode + " or empty")
+		}
+		config.PrivateOnly = true
+	}
+	if len(args) == 3 {
+		config.CollectionMSPIDs = []string{}
+		for _, mspID := range strings.Split(args[2], ",") {
+			if mspID = strings.TrimSpace(mspID); mspID != "" {
+				config.CollectionMSPIDs = append(config.CollectionMSPIDs, mspID)
+			}
+		}
+		if len(config.CollectionMSPIDs) == 0 {
+			return shim.Error("3rd argument collectionMSPIDs must name at least one MSP")
+		}
+	}
+	if err := putConfig(stub, config); err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success(nil)
 }
 
+// getConfig reads the configuration stored by Init, which is empty before
+func getConfig(stub shim.ChaincodeStubInterface) (*Config, error) {
+	configKey, err := stub.CreateCompositeKey("config", []string{"chaincode"})
+	if err != nil {
+		return nil, err
+	}
+	configAsBytes, err := stub.GetState(configKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get configuration: %s", err)
+	}
+	config := &Config{}
+	if configAsBytes == nil {
+		return config, nil
+	}
+	if err = json.Unmarshal(configAsBytes, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func putConfig(stub shim.ChaincodeStubInterface, config *Config) error {
+	configKey, err := stub.CreateCompositeKey("config", []string{"chaincode"})
+	if err != nil {
+		return err
+	}
+	configAsBytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(configKey, configAsBytes)
+}
+
 // Invoke - Our entry point for Invocations
 // ========================================
 func (t *CertificateChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
@@ -63,6 +171,48 @@ func (t *CertificateChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Respo
 		return queryHistoryCertificate(stub, args)
 	} else if function == "queryAllCertificate" {
 		return queryAllCertificate(stub, args)
+	} else if function == "queryCertificateByHash" {
+		return queryCertificateByHash(stub, args)
+	} else if function == "reindexCertificates" {
+		return reindexCertificates(stub, args)
+	} else if function == "anchorBatch" {
+		return anchorBatch(stub, args)
+	} else if function == "getInclusionProof" {
+		return getInclusionProof(stub, args)
+	} else if function == "verifyInclusion" {
+		return verifyInclusion(stub, args)
+	} else if function == "verifyChain" {
+		return verifyChain(stub, args)
+	} else if function == "registerAuthority" {
+		return registerAuthority(stub, args)
+	} else if function == "suspendAuthority" {
+		return suspendAuthority(stub, args)
+	} else if function == "queryAuthority" {
+		return queryAuthority(stub, args)
+	} else if function == "grantAccess" {
+		return grantAccess(stub, args)
+	} else if function == "revokeAccess" {
+		return revokeAccess(stub, args)
+	} else if function == "listGrants" {
+		return listGrants(stub, args)
+	} else if function == "createPrivateCertificate" {
+		return createPrivateCertificate(stub, args)
+	} else if function == "updatePrivateCertificate" {
+		return updatePrivateCertificate(stub, args)
+	} else if function == "verifyCommitment" {
+		return verifyCommitment(stub, args)
+	} else if function == "allocateCertificateID" {
+		return allocateCertificateID(stub, args)
+	} else if function == "suspendCertificate" {
+		return suspendCertificate(stub, args)
+	} else if function == "reinstateCertificate" {
+		return reinstateCertificate(stub, args)
+	} else if function == "revokeCertificate" {
+		return revokeCertificate(stub, args)
+	} else if function == "expireCertificate" {
+		return expireCertificate(stub, args)
+	} else if function == "checkCertificateStatus" {
+		return checkCertificateStatus(stub, args)
 	}
 
 	fmt.Printf("!! invalid function: %s !!", function)
@@ -71,17 +221,27 @@ func (t *CertificateChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Respo
 
 // ============================================================
 // CreateCertificate
+// args: certificateID | "auto", certificateHash [, category
+// [, holder [, validFrom [, validUntil [, contentURI
+// [, mediaType]]]]]]
 // ============================================================
 func createCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	var err error
 	var certificateID int
 
-	if len(args) != 2 {
-		return shim.Error("!! Incorrect number of arguments, Expecting 2 !!")
+	if len(args) < 2 || len(args) > 8 {
+		return shim.Error("!! Incorrect number of arguments, Expecting 2 to 8 !!")
 	}
 
 	// ==== Input Check ====
 	fmt.Println("- start CreateCertificate -")
+	config, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if config.PrivateOnly {
+		return shim.Error("Certificates are private on this channel, use createPrivateCertificate")
+	}
 	if len(args[0]) <= 0 {
 		return shim.Error("1st argument username must be a non-empty string")
 	}
@@ -89,17 +249,58 @@ func createCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Respo
 		return shim.Error("2nd argument enterprisename must be a non-empty string")
 	}
 
-	if certificateID, err = strconv.Atoi(args[0]); err != nil {
+	certificateHash := args[1]
+	category := ""
+	if len(args) >= 3 {
+		category = args[2]
+	}
+	holder := ""
+	if len(args) >= 4 {
+		if len(args[3]) <= 0 {
+			return shim.Error("4th argument holder must be a non-empty string")
+		}
+		holder = args[3]
+	}
+	validFrom, validUntil := "", ""
+	if len(args) >= 5 {
+		validFrom = args[4]
+	}
+	if len(args) >= 6 {
+		validUntil = args[5]
+	}
+	contentURI, mediaType := "", ""
+	if len(args) >= 7 {
+		contentURI = args[6]
+	}
+	if len(args) == 8 {
+		mediaType = args[7]
+	}
+	if contentURI, mediaType, err = documentPointer(contentURI, mediaType); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// construct the key, allocating it for "auto"
+	key := args[0]
+	if key == AutoCertificateID {
+		if key, err = autoCertificateID(stub, category); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if certificateID, err = strconv.Atoi(key); err != nil {
 		return shim.Error("certificateID should be a vaild numeric string")
 	}
-	certificateHash := args[1]
+
+	authority, err := authorizeInvoker(stub, key, category)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = useReservation(stub, key, authority); err != nil {
+		return shim.Error(err.Error())
+	}
 
 	currentTime := timeHelper()
 	fmt.Printf("[%s] <create> certificate %d", currentTime, certificateID)
 
-	// construct the key
-	key := args[0]
-
 	// Check the Record in State
 	certificateRecordAsBytes, err := stub.GetState(key)
 	if err != nil {
@@ -108,7 +309,19 @@ func createCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Respo
 		return shim.Error("Certificate record already exists: " + key)
 	}
 
-	certiticateRecord := &Certificate{CertificateID: key, CertificateHash: certificateHash}
+	certiticateRecord := &Certificate{
+		CertificateID:    key,
+		CertificateHash:  certificateHash,
+		Version:          1,
+		Category:         category,
+		IssuingAuthority: authority.AuthorityID,
+		Holder:           holder,
+		ContentURI:       contentURI,
+		MediaType:        mediaType,
+	}
+	if err = setValidity(stub, certiticateRecord, validFrom, validUntil); err != nil {
+		return shim.Error(err.Error())
+	}
 	if certificateRecordAsBytes, err = json.Marshal(certiticateRecord); err != nil {
 		return shim.Error(err.Error())
 	}
@@ -122,16 +335,20 @@ func createCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Respo
 	if err = createIndex(stub, indexName, []string{certiticateRecord.CertificateID, certiticateRecord.CertificateHash}); err != nil {
 		return shim.Error(err.Error())
 	}
+	indexName = "hash~id"
+	if err = createIndex(stub, indexName, []string{certiticateRecord.CertificateHash, certiticateRecord.CertificateID}); err != nil {
+		return shim.Error(err.Error())
+	}
 
 	fmt.Println("- end CreateCertificate")
-	return shim.Success(nil)
+	return shim.Success([]byte(key))
 }
 
 func updateCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	var err error
 
-	if len(args) != 2 {
-		return shim.Error("!! Incorrect number of arguments, Expecting 3 !!")
+	if len(args) < 3 || len(args) > 5 {
+		return shim.Error("!! Incorrect number of arguments, Expecting 3 to 5 !!")
 	}
 
 	// ==== Input Check ====
@@ -142,8 +359,22 @@ func updateCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Respo
 	if len(args[1]) <= 0 {
 		return shim.Error("2nd argument enterprisename must be a non-empty string")
 	}
+	if !UpdateReasons[args[2]] {
+		return shim.Error("3rd argument reason must be one of correction, reissue or court-order")
+	}
 
 	certificateHash := args[1]
+	reason := args[2]
+	contentURI, mediaType := "", ""
+	if len(args) >= 4 {
+		contentURI = args[3]
+	}
+	if len(args) == 5 {
+		mediaType = args[4]
+	}
+	if contentURI, mediaType, err = documentPointer(contentURI, mediaType); err != nil {
+		return shim.Error(err.Error())
+	}
 
 	// construct the key
 	key := args[0]
@@ -161,12 +392,36 @@ func updateCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Respo
 		return shim.Error(err.Error())
 	}
 
+	if certiticateRecord.Commitment != "" {
+		return shim.Error("Certificate is private, use updatePrivateCertificate: " + key)
+	}
+	if status := recordStatus(certiticateRecord); status == StatusRevoked || status == StatusExpired {
+		return shim.Error(fmt.Sprintf("Certificate %s is %s", key, status))
+	}
+	authority, err := authorizeInvoker(stub, key, certiticateRecord.Category)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	// Remove search index
 	indexName := "id~all"
 	if err = deleteIndex(stub, indexName, []string{certiticateRecord.CertificateID, certiticateRecord.CertificateHash}); err != nil {
 		return shim.Error(err.Error())
 	}
+
+	// chain the new version to the previous one
+	link := chainLink(certificateRecordAsBytes)
+	certiticateRecord.Version = recordVersion(certiticateRecord) + 1
+	certiticateRecord.Reason = reason
+	certiticateRecord.PreviousHash = certiticateRecord.CertificateHash
+	certiticateRecord.ChainLink = link
 	certiticateRecord.CertificateHash = certificateHash
+	certiticateRecord.IssuingAuthority = authority.AuthorityID
+	// the pointer is kept only while it still describes the document
+	if contentURI != "" || certificateHash != certiticateRecord.PreviousHash {
+		certiticateRecord.ContentURI = contentURI
+		certiticateRecord.MediaType = mediaType
+	}
 
 	certificateRecordJSONBytes, err := json.Marshal(certiticateRecord)
 	if err != nil {
@@ -174,18 +429,22 @@ func updateCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Respo
 	}
 
 	currentTime := timeHelper()
-	fmt.Printf("[%s] <update> certificate %s", currentTime, key)
+	fmt.Printf("[%s] <update> certificate %s version %d (%s)", currentTime, key, certiticateRecord.Version, reason)
 
 	// Add Record to State
 	if err = stub.PutState(key, certificateRecordJSONBytes); err != nil {
 		return shim.Error(err.Error())
 	}
 
-	// Add search index
-	indexName = "username~all"
+	// Add search index, the hash~id entry of the previous hash is kept as history
+	indexName = "id~all"
 	if err = createIndex(stub, indexName, []string{certiticateRecord.CertificateID, certiticateRecord.CertificateHash}); err != nil {
 		return shim.Error(err.Error())
 	}
+	indexName = "hash~id"
+	if err = createIndex(stub, indexName, []string{certiticateRecord.CertificateHash, certiticateRecord.CertificateID}); err != nil {
+		return shim.Error(err.Error())
+	}
 
 	fmt.Println("- end updateCertificate")
 	return shim.Success(nil)
@@ -213,6 +472,26 @@ func queryCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Respon
 		return shim.Error(jsonResp)
 	}
 
+	r, err := newReader(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if ok, err := r.canReadBytes(certificateRecordAsBytes); err != nil {
+		return shim.Error(err.Error())
+	} else if !ok {
+		jsonResp := "{\"Error\":\"Access denied to " + key + "\"}"
+		fmt.Println(jsonResp)
+		return shim.Error(jsonResp)
+	}
+
+	// members of the collection read the body of private certificates
+	if certificateRecordAsBytes, err = withPrivateBody(stub, certificateRecordAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	if certificateRecordAsBytes, err = withValidity(stub, certificateRecordAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
 	fmt.Println("- end queryCertificate")
 	return shim.Success(certificateRecordAsBytes)
 }
@@ -229,6 +508,25 @@ func queryHistoryCertificate(stub shim.ChaincodeStubInterface, args []string) pb
 
 	fmt.Printf("- start queryHistoryCertificate: %s\n", key)
 
+	// access follows the current record, only admins read removed ones
+	r, err := newReader(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	certificateRecordAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("Failed to get certificate record: " + err.Error())
+	}
+	readable := r.admin
+	if certificateRecordAsBytes != nil {
+		if readable, err = r.canReadBytes(certificateRecordAsBytes); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if !readable {
+		return shim.Error("Access denied to " + key)
+	}
+
 	resultsIterator, err := stub.GetHistoryForKey(key)
 	if err != nil {
 		return shim.Error(err.Error())
@@ -301,18 +599,28 @@ func removeCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Respo
 		return shim.Error("Certificate does not exist: " + key)
 	}
 
+	certificateRecord := &Certificate{}
+	json.Unmarshal(certificateAsBytes, certificateRecord)
+	if _, err = authorizeInvoker(stub, key, certificateRecord.Category); err != nil {
+		return shim.Error(err.Error())
+	}
+
 	err = stub.DelState(key)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to remove Certificate record: %s", key))
 	}
+	if certificateRecord.Commitment != "" {
+		if err = stub.DelPrivateData(CertificateCollection, key); err != nil {
+			return shim.Error(fmt.Sprintf("Failed to remove private Certificate: %s", key))
+		}
+	}
 
 	// delete index
-	certificateRecord := &Certificate{}
-	json.Unmarshal(certificateAsBytes, certificateRecord)
 	indexName := "id~all"
-	if err = deleteIndex(stub, indexName, []string{certificateRecord.CertificateID, certificateRecord.CertificateHash}); err != nil {
+	if err = deleteIndex(stub, indexName, []string{certificateRecord.CertificateID, certificateRecord.digest()}); err != nil {
 		return shim.Error(err.Error())
 	}
+	// the hash~id entry is kept, queryCertificateByHash reports it as no longer current
 
 	fmt.Println("- end removeCertificate -")
 	return shim.Success(nil)
@@ -325,6 +633,12 @@ func queryAllCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Res
 
 	fmt.Println("- start queryAllCertificate -")
 
+	// only the records the invoker may read are listed
+	r, err := newReader(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	resultsIterator, err := stub.GetStateByRange(startKey, endKey)
 	if err != nil {
 		return shim.Error(err.Error())
@@ -341,6 +655,11 @@ func queryAllCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Res
 		if err != nil {
 			return shim.Error(err.Error())
 		}
+		if ok, err := r.canReadBytes(queryResponse.Value); err != nil {
+			return shim.Error(err.Error())
+		} else if !ok {
+			continue
+		}
 
 		// Add a comma before array members, suppress it for the first array member
 		if bArrayMemberAlreadyWritten == true {
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Reason codes accepted by updateCertificate
+const (
+	ReasonCorrection = "correction"
+	ReasonReissue    = "reissue"
+	ReasonCourtOrder = "court-order"
+)
+
+// UpdateReasons are the valid reason codes of updateCertificate
+var UpdateReasons = map[string]bool{
+	ReasonCorrection: true,
+	ReasonReissue:    true,
+	ReasonCourtOrder: true,
+}
+
+// ChainVerification is the response of verifyChain
+type ChainVerification struct {
+	CertificateID string `json:"CertificateID"`
+	Valid         bool   `json:"Valid"`
+	Versions      int    `json:"Versions"` // number of versions checked
+	BrokenAtTxId  string `json:"BrokenAtTxId,omitempty"`
+	Reason        string `json:"Reason,omitempty"`
+}
+
+// chainLink is the SHA-256 of a certificate version as stored in the
+// ledger, recorded as the ChainLink of the version that replaces it. The
+// stored bytes are hashed rather than a re-encoding, so fields unknown to
+// this version of the chaincode are covered too.
+func chainLink(certificateRecordAsBytes []byte) string {
+	sum := sha256.Sum256(certificateRecordAsBytes)
+	return hex.EncodeToString(sum[:])
+}
+
+// recordVersion is the version of a record, records written before
+// versioning count as the first version
+func recordVersion(certificateRecord *Certificate) int {
+	if certificateRecord.Version == 0 {
+		return 1
+	}
+	return certificateRecord.Version
+}
+
+// ============================================================
+// verifyChain - check every version of a certificate in its
+// history against the version it replaced. A certificate that
+// was removed and created again starts a new chain.
+// ============================================================
+func verifyChain(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("!! Incorrect number of arguments, Expecting 1 !!")
+	}
+
+	key := args[0]
+	fmt.Printf("- start verifyChain: %s\n", key)
+
+	resultsIterator, err := stub.GetHistoryForKey(key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	// versions are returned in commit order, the client-set transaction
+	// timestamps are not used to order them
+	type version struct {
+		txID          string
+		record        *Certificate // nil for a delete
+		recordAsBytes []byte
+	}
+	versions := []version{}
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		v := version{txID: response.TxId}
+		if !response.IsDelete {
+			v.recordAsBytes = response.Value
+			v.record = &Certificate{}
+			if err = json.Unmarshal(response.Value, v.record); err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+		versions = append(versions, v)
+	}
+	if len(versions) == 0 {
+		return shim.Error("Certificate record does not exists: " + key)
+	}
+
+	result := ChainVerification{CertificateID: key, Valid: true}
+	var previous *Certificate
+	var previousAsBytes []byte
+	for _, v := range versions {
+		if v.record == nil {
+			previous = nil
+			continue
+		}
+		result.Versions++
+		if reason := checkChainLink(previous, previousAsBytes, v.record); reason != "" {
+			result.Valid = false
+			result.BrokenAtTxId = v.txID
+			result.Reason = reason
+			break
+		}
+		previous = v.record
+		previousAsBytes = v.recordAsBytes
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end verifyChain: %s\n", resultAsBytes)
+	return shim.Success(resultAsBytes)
+}
+
+// checkChainLink returns why a version does not follow the previous one, or
+// an empty string if it does. previous is nil for the first version, and
+// records written before versioning are accepted without a link.
+func checkChainLink(previous *Certificate, previousAsBytes []byte, current *Certificate) string {
+	if current.Version == 0 {
+		// written before versioning, only older records may precede it
+		if previous != nil && previous.Version != 0 {
+			return fmt.Sprintf("unversioned record follows version %d", previous.Version)
+		}
+		return ""
+	}
+	if previous == nil {
+		if recordVersion(current) != 1 || current.PreviousHash != "" || current.ChainLink != "" {
+			return "first version is linked to a previous version"
+		}
+		return ""
+	}
+
+	if current.Version != recordVersion(previous)+1 {
+		return fmt.Sprintf("version %d follows version %d", current.Version, recordVersion(previous))
+	}
+	if !UpdateReasons[current.Reason] && !StatusReasons[current.Reason] {
+		return "unknown reason code: " + current.Reason
+	}
+	if current.PreviousHash != previous.CertificateHash {
+		return "previous hash does not match the previous version"
+	}
+	if current.ChainLink != chainLink(previousAsBytes) {
+		return "chain link does not match the previous version"
+	}
+	return ""
+}
@@ -0,0 +1,121 @@
+// Command docstore keeps certificate documents in a local content-addressed
+// directory and verifies them against saved queryCertificate responses of
+// the SocialSecurity chaincode, without network access.
+//
+//	docstore [-dir DIR] put FILE             store a document, print its hash and ContentURI
+//	docstore [-dir DIR] get HASH [FILE]      write a stored document to FILE or stdout
+//	docstore [-dir DIR] hash FILE            print the hash of a document
+//	docstore [-dir DIR] verify HASH|FILE RESPONSE
+//	                                         check a document against a queryCertificate response
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/bluezd/BlockChain/SocialSecurity/docstore"
+)
+
+func main() {
+	dir := flag.String("dir", "docstore", "document directory")
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := run(*dir, args[0], args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "docstore:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: docstore [-dir DIR] put FILE | get HASH [FILE] | hash FILE | verify HASH|FILE RESPONSE")
+	flag.PrintDefaults()
+}
+
+func run(dir string, command string, args []string) error {
+	// hashing needs no store
+	if command == "hash" && len(args) == 1 {
+		document, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println(docstore.Hash(document))
+		return nil
+	}
+
+	store, err := docstore.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case command == "put" && len(args) == 1:
+		document, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		hash, err := store.Put(document)
+		if err != nil {
+			return err
+		}
+		fmt.Println(hash)
+		fmt.Println(docstore.URI(hash))
+		return nil
+
+	case command == "get" && (len(args) == 1 || len(args) == 2):
+		document, err := store.Get(args[0])
+		if err != nil {
+			return err
+		}
+		if len(args) == 2 {
+			return ioutil.WriteFile(args[1], document, 0644)
+		}
+		_, err = os.Stdout.Write(document)
+		return err
+
+	case command == "verify" && len(args) == 2:
+		document, err := readDocument(store, args[0])
+		if err != nil {
+			return err
+		}
+		response, err := ioutil.ReadFile(args[1])
+		if err != nil {
+			return err
+		}
+		verification, err := docstore.Verify(document, response)
+		if err != nil {
+			return err
+		}
+		output, err := json.MarshalIndent(verification, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(output))
+		if !verification.Matches {
+			return fmt.Errorf("document does not match certificate %s", verification.CertificateID)
+		}
+		return nil
+	}
+
+	usage()
+	os.Exit(2)
+	return nil
+}
+
+// readDocument reads a stored document by hash, or a file
+func readDocument(store *docstore.Store, name string) ([]byte, error) {
+	if _, err := store.Path(name); err == nil {
+		if document, err := store.Get(name); err != docstore.ErrNotFound {
+			return document, err
+		}
+	}
+	return ioutil.ReadFile(name)
+}
@@ -0,0 +1,172 @@
+// Package docstore keeps certificate documents in a local directory keyed
+// by their content hash, the lowercase hex SHA-256 used as CertificateHash
+// by the SocialSecurity chaincode. It needs no network access: documents
+// are checked against a queryCertificate response saved by the verifier.
+package docstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// URIScheme is the ContentURI scheme of documents kept in a Store,
+// e.g. docstore:<hash>
+const URIScheme = "docstore"
+
+// ErrNotFound is returned for a hash the store does not hold
+var ErrNotFound = errors.New("document not found")
+
+// Store is a content-addressed document directory. A document with hash h
+// is kept at dir/h[:2]/h.
+type Store struct {
+	dir string
+}
+
+// Record is the part of a queryCertificate response needed to verify a
+// document
+type Record struct {
+	CertificateID   string `json:"CertificateID"`
+	CertificateHash string `json:"CertificateHash"`
+	ContentURI      string `json:"ContentURI"`
+	MediaType       string `json:"MediaType"`
+	Validity        *struct {
+		Status string `json:"Status"`
+		Valid  bool   `json:"Valid"`
+	} `json:"Validity"`
+}
+
+// Verification is the result of checking a document against a record
+type Verification struct {
+	CertificateID   string `json:"CertificateID"`
+	CertificateHash string `json:"CertificateHash"`
+	DocumentHash    string `json:"DocumentHash"`
+	Matches         bool   `json:"Matches"`          // the document is the one the record was issued for
+	Status          string `json:"Status,omitempty"` // effective status reported by queryCertificate
+	Valid           bool   `json:"Valid"`            // Matches and the certificate is valid
+}
+
+// Open opens the store in dir, creating the directory if needed
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Hash is the content hash of a document
+func Hash(document []byte) string {
+	sum := sha256.Sum256(document)
+	return hex.EncodeToString(sum[:])
+}
+
+// URI is the ContentURI of a document kept in a Store
+func URI(hash string) string {
+	return URIScheme + ":" + strings.ToLower(hash)
+}
+
+// Put adds a document to the store and returns its hash
+func (s *Store) Put(document []byte) (string, error) {
+	hash := Hash(document)
+	path, _ := s.Path(hash)
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+
+	// write to a temporary file first so a crash never leaves a partial document
+	tmp, err := ioutil.TempFile(filepath.Dir(path), hash+".tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err = tmp.Write(document); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return hash, nil
+}
+
+// Get reads a document, checking it still matches its hash
+func (s *Store) Get(hash string) ([]byte, error) {
+	path, err := s.Path(hash)
+	if err != nil {
+		return nil, err
+	}
+	document, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if Hash(document) != strings.ToLower(hash) {
+		return nil, fmt.Errorf("document %s is corrupt", hash)
+	}
+	return document, nil
+}
+
+// Has reports whether the store holds a document
+func (s *Store) Has(hash string) bool {
+	path, err := s.Path(hash)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// Path is the file of a document in the store
+func (s *Store) Path(hash string) (string, error) {
+	hash = strings.ToLower(hash)
+	if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("%q is not a hex SHA-256 hash", hash)
+	}
+	return filepath.Join(s.dir, hash[:2], hash), nil
+}
+
+// ParseRecord reads a queryCertificate response
+func ParseRecord(response []byte) (*Record, error) {
+	record := &Record{}
+	if err := json.Unmarshal(response, record); err != nil {
+		return nil, fmt.Errorf("not a queryCertificate response: %s", err)
+	}
+	if record.CertificateHash == "" {
+		return nil, errors.New("record carries no CertificateHash, private certificates are verified with verifyCommitment")
+	}
+	return record, nil
+}
+
+// Verify checks a document against a queryCertificate response
+func Verify(document []byte, response []byte) (*Verification, error) {
+	record, err := ParseRecord(response)
+	if err != nil {
+		return nil, err
+	}
+	verification := &Verification{
+		CertificateID:   record.CertificateID,
+		CertificateHash: record.CertificateHash,
+		DocumentHash:    Hash(document),
+	}
+	verification.Matches = strings.EqualFold(verification.DocumentHash, record.CertificateHash)
+	if record.Validity != nil {
+		verification.Status = record.Validity.Status
+		verification.Valid = verification.Matches && record.Validity.Valid
+	}
+	return verification, nil
+}
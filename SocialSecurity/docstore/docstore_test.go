@@ -0,0 +1,126 @@
+package docstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testStore(t *testing.T) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "docstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := Open(filepath.Join(dir, "store"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return store, func() { os.RemoveAll(dir) }
+}
+
+func TestPutGet(t *testing.T) {
+	store, cleanup := testStore(t)
+	defer cleanup()
+
+	document := []byte("certificate of social security")
+	hash, err := store.Put(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != Hash(document) {
+		t.Errorf("Put returned %s, want %s", hash, Hash(document))
+	}
+	if !store.Has(hash) || !store.Has(strings.ToUpper(hash)) {
+		t.Errorf("Has(%s) = false after Put", hash)
+	}
+	// a second Put of the same document is a no-op
+	if again, err := store.Put(document); err != nil || again != hash {
+		t.Errorf("second Put = %s, %v", again, err)
+	}
+
+	got, err := store.Get(strings.ToUpper(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(document) {
+		t.Errorf("Get returned %q, want %q", got, document)
+	}
+	if URI(strings.ToUpper(hash)) != URIScheme+":"+hash {
+		t.Errorf("URI(%s) = %s", hash, URI(hash))
+	}
+}
+
+func TestGetMissing(t *testing.T) {
+	store, cleanup := testStore(t)
+	defer cleanup()
+
+	if _, err := store.Get(Hash([]byte("missing"))); err != ErrNotFound {
+		t.Errorf("Get of a missing document = %v, want ErrNotFound", err)
+	}
+	if store.Has(Hash([]byte("missing"))) {
+		t.Error("Has of a missing document = true")
+	}
+	for _, hash := range []string{"", "abc", strings.Repeat("zz", 32), "../" + Hash(nil)} {
+		if _, err := store.Get(hash); err == nil || err == ErrNotFound {
+			t.Errorf("Get(%q) = %v, want an invalid hash error", hash, err)
+		}
+	}
+}
+
+func TestGetCorrupt(t *testing.T) {
+	store, cleanup := testStore(t)
+	defer cleanup()
+
+	hash, err := store.Put([]byte("original"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, _ := store.Path(hash)
+	if err = ioutil.WriteFile(path, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Get(hash); err == nil || err == ErrNotFound {
+		t.Errorf("Get of a changed document = %v, want a corruption error", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	document := []byte("certificate of social security")
+	hash := Hash(document)
+	tests := []struct {
+		name     string
+		document []byte
+		response string
+		matches  bool
+		valid    bool
+		status   string
+	}{
+		{"valid", document, `{"CertificateID":"1","CertificateHash":"` + hash + `","Validity":{"Status":"active","Valid":true}}`, true, true, "active"},
+		{"upper case hash", document, `{"CertificateID":"1","CertificateHash":"` + strings.ToUpper(hash) + `","Validity":{"Status":"active","Valid":true}}`, true, true, "active"},
+		{"revoked", document, `{"CertificateID":"1","CertificateHash":"` + hash + `","Validity":{"Status":"revoked","Valid":false}}`, true, false, "revoked"},
+		{"other document", []byte("forged"), `{"CertificateID":"1","CertificateHash":"` + hash + `","Validity":{"Status":"active","Valid":true}}`, false, false, "active"},
+		{"no validity", document, `{"CertificateID":"1","CertificateHash":"` + hash + `"}`, true, false, ""},
+	}
+	for _, test := range tests {
+		verification, err := Verify(test.document, []byte(test.response))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if verification.Matches != test.matches || verification.Valid != test.valid || verification.Status != test.status {
+			t.Errorf("%s: got Matches %v, Valid %v, Status %q", test.name, verification.Matches, verification.Valid, verification.Status)
+		}
+		if verification.CertificateID != "1" || verification.DocumentHash != Hash(test.document) {
+			t.Errorf("%s: got CertificateID %q, DocumentHash %s", test.name, verification.CertificateID, verification.DocumentHash)
+		}
+	}
+
+	for _, response := range []string{"not json", `{"CertificateID":"1","Commitment":"abc"}`} {
+		if _, err := Verify(document, []byte(response)); err == nil {
+			t.Errorf("Verify accepted the response %s", response)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+)
+
+// documentPointer checks the optional ContentURI and MediaType of the
+// document a CertificateHash was computed from. The URI must be absolute,
+// e.g. https://..., ipfs://... or docstore:<hash> for the local store of
+// SocialSecurity/docstore. A media type needs a URI.
+func documentPointer(contentURI string, mediaType string) (string, string, error) {
+	if contentURI == "" {
+		if mediaType != "" {
+			return "", "", fmt.Errorf("mediaType needs a contentURI")
+		}
+		return "", "", nil
+	}
+	uri, err := url.Parse(contentURI)
+	if err != nil || uri.Scheme == "" {
+		return "", "", fmt.Errorf("contentURI must be an absolute URI")
+	}
+	if mediaType != "" {
+		parsed, params, err := mime.ParseMediaType(mediaType)
+		if err != nil {
+			return "", "", fmt.Errorf("mediaType is invalid: %s", err)
+		}
+		mediaType = mime.FormatMediaType(parsed, params)
+	}
+	return contentURI, mediaType, nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// GrantObjectType is the composite key object type of access grants, keyed
+// by certificateID and verifier MSP ID
+const GrantObjectType = "grant"
+
+// Grant lets the members of a verifier organization read a certificate
+// until ExpiresAt. The expiry is advisory: it is checked against the
+// transaction timestamp, which the client sets and which no one validates on
+// a query. Use revokeAccess to withdraw access for good.
+type Grant struct {
+	CertificateID string `json:"CertificateID"`
+	Holder        string `json:"Holder"`
+	VerifierMSPID string `json:"VerifierMSPID"`
+	GrantedAt     string `json:"GrantedAt"`
+	ExpiresAt     string `json:"ExpiresAt"` // RFC 3339
+}
+
+// reader is the invoker of a query and what it may read. Admins, the holder
+// of a record, the authorities permitted to write it and verifiers holding
+// an unexpired grant from the holder may read a record.
+//
+// Grants do not cover checkCertificateStatus, which other chaincodes call
+// with the identity of their own clients. Any channel member can use it to
+// learn whether a certificate ID exists and whether it is valid. IDs are
+// numeric with a check digit, so they can be enumerated; the status of a
+// certificate is not a secret, only its hash and holder are.
+type reader struct {
+	stub        shim.ChaincodeStubInterface
+	id          string
+	mspID       string
+	admin       bool
+	authorities []*Authority
+	now         time.Time
+}
+
+// ============================================================
+// grantAccess - let a verifier organization read a certificate
+// until a time, see Grant. Holder only, a new grant replaces
+// the old one.
+// args: certificateID, verifierMSPID, expiresAt (RFC 3339)
+// ============================================================
+func grantAccess(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("!! Incorrect number of arguments, Expecting 3 !!")
+	}
+	if len(args[1]) <= 0 {
+		return shim.Error("2nd argument verifierMSPID must be a non-empty string")
+	}
+	expiresAt, err := time.Parse(time.RFC3339, args[2])
+	if err != nil {
+		return shim.Error("3rd argument expiresAt must be an RFC 3339 time")
+	}
+
+	fmt.Println("- start grantAccess -")
+	certificateRecord, err := holderCertificate(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	grantedAt, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if now, _ := time.Parse(time.RFC3339, grantedAt); !expiresAt.After(now) {
+		return shim.Error("3rd argument expiresAt must be in the future")
+	}
+
+	grant := &Grant{
+		CertificateID: certificateRecord.CertificateID,
+		Holder:        certificateRecord.Holder,
+		VerifierMSPID: args[1],
+		GrantedAt:     grantedAt,
+		ExpiresAt:     expiresAt.UTC().Format(time.RFC3339),
+	}
+	grantKey, err := stub.CreateCompositeKey(GrantObjectType, []string{grant.CertificateID, grant.VerifierMSPID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	grantAsBytes, err := json.Marshal(grant)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = stub.PutState(grantKey, grantAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end grantAccess -")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// revokeAccess - withdraw the grant of a verifier organization
+// Holder only.
+// args: certificateID, verifierMSPID
+// ============================================================
+func revokeAccess(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("!! Incorrect number of arguments, Expecting 2 !!")
+	}
+
+	fmt.Println("- start revokeAccess -")
+	certificateRecord, err := holderCertificate(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	grantKey, err := stub.CreateCompositeKey(GrantObjectType, []string{certificateRecord.CertificateID, args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	grantAsBytes, err := stub.GetState(grantKey)
+	if err != nil {
+		return shim.Error("Failed to get grant: " + err.Error())
+	} else if grantAsBytes == nil {
+		return shim.Error("Grant does not exist: " + args[1])
+	}
+	if err = stub.DelState(grantKey); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end revokeAccess -")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// listGrants - the unexpired grants the invoker may see: all of
+// them for an admin, otherwise those of its own records and
+// those given to its organization
+// args: [certificateID]
+// ============================================================
+func listGrants(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) > 1 {
+		return shim.Error("!! Incorrect number of arguments, Expecting 0 or 1 !!")
+	}
+	r, err := newReader(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- start listGrants -")
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(GrantObjectType, args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	grants := []Grant{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		grant := Grant{}
+		if err = json.Unmarshal(responseRange.Value, &grant); err != nil {
+			return shim.Error(err.Error())
+		}
+		if !r.active(&grant) {
+			continue
+		}
+		if r.admin || grant.Holder == r.id || grant.VerifierMSPID == r.mspID {
+			grants = append(grants, grant)
+		}
+	}
+
+	grantsAsBytes, err := json.Marshal(grants)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- end listGrants:\n   %s\n", grantsAsBytes)
+	return shim.Success(grantsAsBytes)
+}
+
+// holderCertificate reads a certificate the invoker is the holder of
+func holderCertificate(stub shim.ChaincodeStubInterface, certificateID string) (*Certificate, error) {
+	certificateRecordAsBytes, err := stub.GetState(certificateID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get certificate record: %s", err)
+	} else if certificateRecordAsBytes == nil {
+		return nil, fmt.Errorf("Certificate record does not exists: %s", certificateID)
+	}
+	certificateRecord := &Certificate{}
+	if err = json.Unmarshal(certificateRecordAsBytes, certificateRecord); err != nil {
+		return nil, err
+	}
+
+	id, err := cid.GetID(stub)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get invoker ID: %s", err)
+	}
+	if certificateRecord.Holder == "" || certificateRecord.Holder != id {
+		return nil, fmt.Errorf("Only the holder can manage access to certificate %s", certificateID)
+	}
+	return certificateRecord, nil
+}
+
+// newReader loads the identity of the invoker
+func newReader(stub shim.ChaincodeStubInterface) (*reader, error) {
+	r := &reader{stub: stub, admin: isAdmin(stub)}
+	var err error
+	if r.id, err = cid.GetID(stub); err != nil {
+		return nil, fmt.Errorf("Failed to get invoker ID: %s", err)
+	}
+	if r.mspID, err = cid.GetMSPID(stub); err != nil {
+		return nil, fmt.Errorf("Failed to get invoker MSP ID: %s", err)
+	}
+	if r.authorities, err = invokerAuthorities(stub); err != nil {
+		return nil, err
+	}
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	r.now = time.Unix(timestamp.Seconds, int64(timestamp.Nanos))
+	return r, nil
+}
+
+// canRead reports whether the invoker may read a certificate record
+func (r *reader) canRead(certificateRecord *Certificate) (bool, error) {
+	if r.admin || (certificateRecord.Holder != "" && certificateRecord.Holder == r.id) {
+		return true, nil
+	}
+	if _, err := authorityFor(r.authorities, certificateRecord.CertificateID, certificateRecord.Category); err == nil {
+		return true, nil
+	}
+	if certificateRecord.Holder == "" {
+		return false, nil
+	}
+
+	grantKey, err := r.stub.CreateCompositeKey(GrantObjectType, []string{certificateRecord.CertificateID, r.mspID})
+	if err != nil {
+		return false, err
+	}
+	grantAsBytes, err := r.stub.GetState(grantKey)
+	if err != nil {
+		return false, fmt.Errorf("Failed to get grant: %s", err)
+	} else if grantAsBytes == nil {
+		return false, nil
+	}
+	grant := &Grant{}
+	if err = json.Unmarshal(grantAsBytes, grant); err != nil {
+		return false, err
+	}
+	// a grant only counts for the holder that gave it
+	return grant.Holder == certificateRecord.Holder && r.active(grant), nil
+}
+
+// canReadBytes is canRead for a stored record
+func (r *reader) canReadBytes(certificateRecordAsBytes []byte) (bool, error) {
+	certificateRecord := &Certificate{}
+	if err := json.Unmarshal(certificateRecordAsBytes, certificateRecord); err != nil {
+		return r.admin, nil
+	}
+	return r.canRead(certificateRecord)
+}
+
+// active reports whether a grant has not expired at the transaction
+// timestamp, see Grant
+func (r *reader) active(grant *Grant) bool {
+	expiresAt, err := time.Parse(time.RFC3339, grant.ExpiresAt)
+	return err == nil && expiresAt.After(r.now)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// HashLookup is a certificate ID that has used a certificate hash
+type HashLookup struct {
+	CertificateID string `json:"CertificateID"`
+	Current       bool   `json:"Current"` // the certificate still holds the hash
+}
+
+// ============================================================
+// queryCertificateByHash - every certificate ID that has ever
+// used a hash that the invoker may read, through the hash~id
+// index
+// ============================================================
+func queryCertificateByHash(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("!! Incorrect number of arguments, Expecting 1 !!")
+	}
+	if len(args[0]) <= 0 {
+		return shim.Error("1st argument certificateHash must be a non-empty string")
+	}
+	certificateHash := args[0]
+
+	fmt.Printf("- start queryCertificateByHash: %s\n", certificateHash)
+
+	r, err := newReader(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("hash~id", []string{certificateHash})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	lookups := []HashLookup{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		// the entry is current while the record still holds the hash,
+		// only admins see the entries of removed records
+		lookup := HashLookup{CertificateID: compositeKeyParts[1]}
+		certificateRecordAsBytes, err := stub.GetState(lookup.CertificateID)
+		if err != nil {
+			return shim.Error("Failed to get certificate record: " + err.Error())
+		}
+		readable := r.admin
+		if certificateRecordAsBytes != nil {
+			certificateRecord := &Certificate{}
+			if err = json.Unmarshal(certificateRecordAsBytes, certificateRecord); err != nil {
+				return shim.Error(err.Error())
+			}
+			if readable, err = r.canRead(certificateRecord); err != nil {
+				return shim.Error(err.Error())
+			}
+			lookup.Current = certificateRecord.CertificateHash == certificateHash
+		}
+		if readable {
+			lookups = append(lookups, lookup)
+		}
+	}
+
+	lookupsAsBytes, err := json.Marshal(lookups)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("- end queryCertificateByHash:\n   %s\n", lookupsAsBytes)
+	return shim.Success(lookupsAsBytes)
+}
+
+// ============================================================
+// reindexCertificates - build the hash~id index from the
+// history of every certificate, and replace the username~all
+// entries written by earlier versions of updateCertificate
+// with id~all entries. Removed certificates are not covered.
+// Admin only.
+// ============================================================
+func reindexCertificates(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("Only an admin can rebuild the indexes")
+	}
+
+	fmt.Println("- start reindexCertificates -")
+
+	// drop the misnamed entries, the id~all entries are rebuilt below
+	oldIterator, err := stub.GetStateByPartialCompositeKey("username~all", []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for oldIterator.HasNext() {
+		responseRange, err := oldIterator.Next()
+		if err != nil {
+			oldIterator.Close()
+			return shim.Error(err.Error())
+		}
+		if err = stub.DelState(responseRange.Key); err != nil {
+			oldIterator.Close()
+			return shim.Error(err.Error())
+		}
+	}
+	oldIterator.Close()
+
+	resultsIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	reindexed := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		certificateRecord := &Certificate{}
+		if err = json.Unmarshal(queryResponse.Value, certificateRecord); err != nil || certificateRecord.CertificateID != queryResponse.Key {
+			// not a certificate record
+			continue
+		}
+
+		if err = createIndex(stub, "id~all", []string{certificateRecord.CertificateID, certificateRecord.digest()}); err != nil {
+			return shim.Error(err.Error())
+		}
+		// private certificates keep their hash off the channel ledger
+		if certificateRecord.CertificateHash != "" {
+			if err = createIndex(stub, "hash~id", []string{certificateRecord.CertificateHash, certificateRecord.CertificateID}); err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+		if err = indexHashHistory(stub, certificateRecord.CertificateID); err != nil {
+			return shim.Error(err.Error())
+		}
+		reindexed++
+	}
+
+	fmt.Printf("- end reindexCertificates: %d certificates\n", reindexed)
+	return shim.Success([]byte(fmt.Sprintf("%d", reindexed)))
+}
+
+// indexHashHistory adds a hash~id entry for every hash a certificate has held
+func indexHashHistory(stub shim.ChaincodeStubInterface, certificateID string) error {
+	historyIterator, err := stub.GetHistoryForKey(certificateID)
+	if err != nil {
+		return err
+	}
+	defer historyIterator.Close()
+
+	for historyIterator.HasNext() {
+		response, err := historyIterator.Next()
+		if err != nil {
+			return err
+		}
+		if response.IsDelete {
+			continue
+		}
+		certificateRecord := &Certificate{}
+		if err = json.Unmarshal(response.Value, certificateRecord); err != nil || certificateRecord.CertificateHash == "" {
+			continue
+		}
+		if err = createIndex(stub, "hash~id", []string{certificateRecord.CertificateHash, certificateID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+// Package merkle builds and verifies the Merkle trees anchored by the
+// SocialSecurity chaincode with anchorBatch. Trees follow RFC 6962: leaf and
+// interior node hashes use distinct SHA-256 prefixes, so a leaf can never be
+// passed off as a node. The package has no Fabric dependency and can be used
+// by offline clients to check an inclusion proof against an anchored root.
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// Proof shows that a certificate leaf is part of an anchored batch
+type Proof struct {
+	CertificateID   string   `json:"CertificateID"`
+	CertificateHash string   `json:"CertificateHash"`
+	BatchID         string   `json:"BatchID"`
+	Index           uint64   `json:"Index"` // position of the leaf in the batch
+	Size            uint64   `json:"Size"`  // number of leaves in the batch
+	Path            []string `json:"Path"`  // hex encoded sibling hashes, from the leaf up
+	Root            string   `json:"Root"`  // hex encoded root of the batch
+}
+
+// Leaf returns the leaf data of a certificate, the JSON array [id, hash]
+func Leaf(certificateID string, certificateHash string) []byte {
+	leaf, _ := json.Marshal([]string{certificateID, certificateHash})
+	return leaf
+}
+
+// LeafHash returns the hash of leaf data
+func LeafHash(leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+// NodeHash returns the hash of an interior node
+func NodeHash(left []byte, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Root returns the root hash of a tree over leaves
+func Root(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		empty := sha256.Sum256(nil)
+		return empty[:]
+	case 1:
+		return LeafHash(leaves[0])
+	}
+	k := split(len(leaves))
+	return NodeHash(Root(leaves[:k]), Root(leaves[k:]))
+}
+
+// Path returns the sibling hashes from leaf index up to the root
+func Path(leaves [][]byte, index int) ([][]byte, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, fmt.Errorf("leaf index %d out of range", index)
+	}
+	return path(leaves, index), nil
+}
+
+func path(leaves [][]byte, index int) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := split(len(leaves))
+	if index < k {
+		return append(path(leaves[:k], index), Root(leaves[k:]))
+	}
+	return append(path(leaves[k:], index-k), Root(leaves[:k]))
+}
+
+// Paths returns the root hash of a tree over leaves and the sibling hashes
+// of every leaf up to the root, in a single pass over the tree
+func Paths(leaves [][]byte) ([]byte, [][][]byte) {
+	paths := make([][][]byte, len(leaves))
+	return treePaths(leaves, paths), paths
+}
+
+func treePaths(leaves [][]byte, paths [][][]byte) []byte {
+	if len(leaves) <= 1 {
+		return Root(leaves)
+	}
+	k := split(len(leaves))
+	left := treePaths(leaves[:k], paths[:k])
+	right := treePaths(leaves[k:], paths[k:])
+	for i := range paths {
+		if i < k {
+			paths[i] = append(paths[i], right)
+		} else {
+			paths[i] = append(paths[i], left)
+		}
+	}
+	return NodeHash(left, right)
+}
+
+// RootFromPath recomputes the root of a tree of size leaves from a leaf at
+// index and its sibling path
+func RootFromPath(leaf []byte, index uint64, size uint64, siblings [][]byte) ([]byte, error) {
+	if index >= size {
+		return nil, errors.New("leaf index out of range")
+	}
+
+	fn, sn := index, size-1
+	r := LeafHash(leaf)
+	for _, sibling := range siblings {
+		if sn == 0 {
+			return nil, errors.New("path is too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			r = NodeHash(sibling, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = NodeHash(r, sibling)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return nil, errors.New("path is too short")
+	}
+	return r, nil
+}
+
+// Verify checks that the proof leads from its certificate leaf to root. Pass
+// the root anchored on the ledger, not the root carried by the proof.
+func (p *Proof) Verify(root string) error {
+	expected, err := hex.DecodeString(root)
+	if err != nil {
+		return errors.New("root is not hex encoded")
+	}
+	siblings := make([][]byte, len(p.Path))
+	for i, sibling := range p.Path {
+		if siblings[i], err = hex.DecodeString(sibling); err != nil {
+			return errors.New("path is not hex encoded")
+		}
+	}
+
+	computed, err := RootFromPath(Leaf(p.CertificateID, p.CertificateHash), p.Index, p.Size, siblings)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(computed, expected) {
+		return errors.New("proof does not match the root")
+	}
+	return nil
+}
+
+// split returns the largest power of two smaller than n, for n > 1
+func split(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
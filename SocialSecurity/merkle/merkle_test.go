@@ -0,0 +1,123 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func testLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = Leaf(fmt.Sprint(i+1), fmt.Sprintf("hash%d", i+1))
+	}
+	return leaves
+}
+
+func testProof(t *testing.T, leaves [][]byte, index int) *Proof {
+	siblings, err := Path(leaves, index)
+	if err != nil {
+		t.Fatalf("Path(%d leaves, %d): %s", len(leaves), index, err)
+	}
+	proof := &Proof{
+		CertificateID:   fmt.Sprint(index + 1),
+		CertificateHash: fmt.Sprintf("hash%d", index+1),
+		Index:           uint64(index),
+		Size:            uint64(len(leaves)),
+		Root:            hex.EncodeToString(Root(leaves)),
+	}
+	for _, sibling := range siblings {
+		proof.Path = append(proof.Path, hex.EncodeToString(sibling))
+	}
+	return proof
+}
+
+func TestRoot(t *testing.T) {
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+	tests := []struct {
+		leaves [][]byte
+		root   []byte
+	}{
+		{[][]byte{a}, LeafHash(a)},
+		{[][]byte{a, b}, NodeHash(LeafHash(a), LeafHash(b))},
+		// an odd leaf is paired with the subtree on its left, not duplicated
+		{[][]byte{a, b, c}, NodeHash(NodeHash(LeafHash(a), LeafHash(b)), LeafHash(c))},
+	}
+	for _, test := range tests {
+		if root := Root(test.leaves); !bytes.Equal(root, test.root) {
+			t.Errorf("Root(%q) = %x, want %x", test.leaves, root, test.root)
+		}
+	}
+
+	// a leaf can not be passed off as the node it hashes to
+	if bytes.Equal(LeafHash(append(LeafHash(a), LeafHash(b)...)), Root([][]byte{a, b})) {
+		t.Error("leaf and node hashes collide")
+	}
+}
+
+func TestProofRoundTrip(t *testing.T) {
+	for n := 1; n <= 17; n++ {
+		leaves := testLeaves(n)
+		root, paths := Paths(leaves)
+		if !bytes.Equal(root, Root(leaves)) {
+			t.Fatalf("Paths root of %d leaves differs from Root", n)
+		}
+		for i := range leaves {
+			proof := testProof(t, leaves, i)
+			if err := proof.Verify(proof.Root); err != nil {
+				t.Errorf("leaf %d of %d: %s", i, n, err)
+			}
+			if len(paths[i]) != len(proof.Path) {
+				t.Errorf("leaf %d of %d: Paths gives %d siblings, Path %d", i, n, len(paths[i]), len(proof.Path))
+			}
+		}
+	}
+}
+
+func TestProofTampered(t *testing.T) {
+	for _, n := range []int{2, 3, 5, 8} {
+		leaves := testLeaves(n)
+		for i := range leaves {
+			root := testProof(t, leaves, i).Root
+			tamper := map[string]func(p *Proof){
+				"hash":  func(p *Proof) { p.CertificateHash += "x" },
+				"id":    func(p *Proof) { p.CertificateID += "0" },
+				"index": func(p *Proof) { p.Index = uint64((i + 1) % n) },
+				"path":  func(p *Proof) { p.Path[0] = hex.EncodeToString(LeafHash([]byte("x"))) },
+				"short": func(p *Proof) { p.Path = p.Path[:len(p.Path)-1] },
+				"long":  func(p *Proof) { p.Path = append(p.Path, p.Path[0]) },
+			}
+			for name, change := range tamper {
+				proof := testProof(t, leaves, i)
+				change(proof)
+				if err := proof.Verify(root); err == nil {
+					t.Errorf("leaf %d of %d: proof with changed %s verified", i, n, name)
+				}
+			}
+		}
+	}
+}
+
+func TestVerifyAgainstOtherRoot(t *testing.T) {
+	proof := testProof(t, testLeaves(3), 1)
+	other := hex.EncodeToString(Root(testLeaves(4)))
+	if err := proof.Verify(other); err == nil {
+		t.Error("proof verified against the root of another batch")
+	}
+	if err := proof.Verify("not hex"); err == nil {
+		t.Error("proof verified against a root that is not hex encoded")
+	}
+}
+
+func TestPathOutOfRange(t *testing.T) {
+	leaves := testLeaves(3)
+	for _, index := range []int{-1, 3} {
+		if _, err := Path(leaves, index); err == nil {
+			t.Errorf("Path(3 leaves, %d) did not fail", index)
+		}
+	}
+	if _, err := RootFromPath(leaves[0], 3, 3, nil); err == nil {
+		t.Error("RootFromPath accepted an index past the size")
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// CertificateCollection is the private data collection holding the bodies
+// of private certificates. collections_config.json defines it for the
+// example Org1MSP and Org2MSP organizations: replace them in its policy with
+// the MSPs of the organizations that may read private certificates, and pass
+// the same MSPs to Init as collectionMSPIDs. maxPeerCount should not exceed
+// the number of member peers.
+const CertificateCollection = "collectionCertificates"
+
+// CertificateTransientKey is the transient map entry carrying a
+// PrivateCertificate to createPrivateCertificate and updatePrivateCertificate
+const CertificateTransientKey = "certificate"
+
+// MinSaltLength is the minimum length of the salt of a commitment
+const MinSaltLength = 16
+
+// PrivateCertificate is the body of a private certificate. Only its
+// commitment is written to the channel ledger.
+type PrivateCertificate struct {
+	CertificateID   string `json:"CertificateID"`
+	CertificateHash string `json:"CertificateHash"`
+	Salt            string `json:"Salt"`
+}
+
+// CommitmentCheck is the response of verifyCommitment
+type CommitmentCheck struct {
+	CertificateID string `json:"CertificateID"`
+	Valid         bool   `json:"Valid"`
+}
+
+// ============================================================
+// createPrivateCertificate - create a certificate whose body is
+// taken from the transient map and kept in the private
+// collection, leaving a salted commitment on the channel ledger.
+// Returns the certificate ID, which is allocated for "auto".
+// args: certificateID [, category [, holder [, validFrom [, validUntil]]]]
+// transient: certificate = {"CertificateHash": ..., "Salt": ...}
+// ============================================================
+func createPrivateCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 1 || len(args) > 5 {
+		return shim.Error("!! Incorrect number of arguments, Expecting 1 to 5 !!")
+	}
+
+	fmt.Println("- start createPrivateCertificate -")
+	category := ""
+	if len(args) >= 2 {
+		category = args[1]
+	}
+	holder := ""
+	if len(args) >= 3 {
+		if len(args[2]) <= 0 {
+			return shim.Error("3rd argument holder must be a non-empty string")
+		}
+		holder = args[2]
+	}
+	validFrom, validUntil := "", ""
+	if len(args) >= 4 {
+		validFrom = args[3]
+	}
+	if len(args) == 5 {
+		validUntil = args[4]
+	}
+
+	key := args[0]
+	var err error
+	if key == AutoCertificateID {
+		if key, err = autoCertificateID(stub, category); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if _, err = strconv.Atoi(key); err != nil {
+		return shim.Error("certificateID should be a vaild numeric string")
+	}
+
+	body, err := transientCertificate(stub, key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	authority, err := authorizeInvoker(stub, key, category)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = useReservation(stub, key, authority); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	certificateRecordAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("Failed to get certificate record: " + err.Error())
+	} else if certificateRecordAsBytes != nil {
+		return shim.Error("Certificate record already exists: " + key)
+	}
+
+	certificateRecord := &Certificate{
+		CertificateID:    key,
+		Commitment:       commitment(body),
+		Version:          1,
+		Category:         category,
+		IssuingAuthority: authority.AuthorityID,
+		Holder:           holder,
+	}
+	if err = setValidity(stub, certificateRecord, validFrom, validUntil); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = putPrivateCertificate(stub, certificateRecord, body); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = createIndex(stub, "id~all", []string{key, certificateRecord.digest()}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end createPrivateCertificate -")
+	return shim.Success([]byte(key))
+}
+
+// ============================================================
+// updatePrivateCertificate - replace the body of a private
+// certificate, see createPrivateCertificate
+// args: certificateID, reason
+// transient: certificate = {"CertificateHash": ..., "Salt": ...}
+// ============================================================
+func updatePrivateCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("!! Incorrect number of arguments, Expecting 2 !!")
+	}
+	if !UpdateReasons[args[1]] {
+		return shim.Error("2nd argument reason must be one of correction, reissue or court-order")
+	}
+
+	fmt.Println("- start updatePrivateCertificate -")
+	key := args[0]
+	body, err := transientCertificate(stub, key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	certificateRecordAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("Failed to get certificate record: " + err.Error())
+	} else if certificateRecordAsBytes == nil {
+		return shim.Error("Certificate record does not exists: " + key)
+	}
+	certificateRecord := &Certificate{}
+	if err = json.Unmarshal(certificateRecordAsBytes, certificateRecord); err != nil {
+		return shim.Error(err.Error())
+	}
+	if certificateRecord.Commitment == "" {
+		return shim.Error("Certificate is not private, use updateCertificate: " + key)
+	}
+	if status := recordStatus(certificateRecord); status == StatusRevoked || status == StatusExpired {
+		return shim.Error(fmt.Sprintf("Certificate %s is %s", key, status))
+	}
+
+	authority, err := authorizeInvoker(stub, key, certificateRecord.Category)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = deleteIndex(stub, "id~all", []string{key, certificateRecord.digest()}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the chain link covers the previous commitment
+	link := chainLink(certificateRecordAsBytes)
+	certificateRecord.Version = recordVersion(certificateRecord) + 1
+	certificateRecord.Reason = args[1]
+	certificateRecord.ChainLink = link
+	certificateRecord.Commitment = commitment(body)
+	certificateRecord.IssuingAuthority = authority.AuthorityID
+
+	if err = putPrivateCertificate(stub, certificateRecord, body); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = createIndex(stub, "id~all", []string{key, certificateRecord.digest()}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end updatePrivateCertificate -")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// verifyCommitment - check a certificate hash and salt given
+// by the holder against the commitment of a private
+// certificate, for organizations outside the collection
+// args: certificateID, certificateHash, salt
+// ============================================================
+func verifyCommitment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("!! Incorrect number of arguments, Expecting 3 !!")
+	}
+
+	certificateRecordAsBytes, err := stub.GetState(args[0])
+	if err != nil {
+		return shim.Error("Failed to get certificate record: " + err.Error())
+	} else if certificateRecordAsBytes == nil {
+		return shim.Error("Certificate record does not exists: " + args[0])
+	}
+	certificateRecord := &Certificate{}
+	if err = json.Unmarshal(certificateRecordAsBytes, certificateRecord); err != nil {
+		return shim.Error(err.Error())
+	}
+	if certificateRecord.Commitment == "" {
+		return shim.Error("Certificate is not private: " + args[0])
+	}
+
+	body := &PrivateCertificate{CertificateID: args[0], CertificateHash: args[1], Salt: args[2]}
+	result := CommitmentCheck{
+		CertificateID: args[0],
+		Valid:         commitment(body) == certificateRecord.Commitment,
+	}
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
+
+// commitment is the hex SHA-256 of the JSON array [salt, certificateHash]
+func commitment(body *PrivateCertificate) string {
+	leaf, _ := json.Marshal([]string{body.Salt, body.CertificateHash})
+	sum := sha256.Sum256(leaf)
+	return hex.EncodeToString(sum[:])
+}
+
+// transientCertificate reads the certificate body from the transient map
+func transientCertificate(stub shim.ChaincodeStubInterface, certificateID string) (*PrivateCertificate, error) {
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get transient map: %s", err)
+	}
+	bodyAsBytes, ok := transient[CertificateTransientKey]
+	if !ok {
+		return nil, fmt.Errorf("Transient map must hold the %q entry", CertificateTransientKey)
+	}
+	body := &PrivateCertificate{}
+	if err = json.Unmarshal(bodyAsBytes, body); err != nil {
+		return nil, fmt.Errorf("Transient %q entry must be a certificate JSON: %s", CertificateTransientKey, err)
+	}
+	if len(body.CertificateHash) <= 0 {
+		return nil, fmt.Errorf("CertificateHash must be a non-empty string")
+	}
+	if len(body.Salt) < MinSaltLength {
+		return nil, fmt.Errorf("Salt must be at least %d characters", MinSaltLength)
+	}
+	body.CertificateID = certificateID
+	return body, nil
+}
+
+// putPrivateCertificate writes the public record and the private body
+func putPrivateCertificate(stub shim.ChaincodeStubInterface, certificateRecord *Certificate, body *PrivateCertificate) error {
+	certificateRecordAsBytes, err := json.Marshal(certificateRecord)
+	if err != nil {
+		return err
+	}
+	if err = stub.PutState(certificateRecord.CertificateID, certificateRecordAsBytes); err != nil {
+		return err
+	}
+	bodyAsBytes, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return stub.PutPrivateData(CertificateCollection, certificateRecord.CertificateID, bodyAsBytes)
+}
+
+// withPrivateBody fills in the CertificateHash of a private record from the
+// collection for clients of its member organizations. Peers of other
+// organizations may still hold the body, so the invoker's MSP is checked
+// against Config.CollectionMSPIDs, and the record keeps just its commitment
+// for other clients or when the body can not be read. Other records are
+// returned as-is.
+func withPrivateBody(stub shim.ChaincodeStubInterface, certificateRecordAsBytes []byte) ([]byte, error) {
+	certificateRecord := &Certificate{}
+	if err := json.Unmarshal(certificateRecordAsBytes, certificateRecord); err != nil || certificateRecord.Commitment == "" {
+		return certificateRecordAsBytes, nil
+	}
+	member, err := isCollectionMember(stub)
+	if err != nil {
+		return nil, err
+	} else if !member {
+		return certificateRecordAsBytes, nil
+	}
+
+	bodyAsBytes, err := stub.GetPrivateData(CertificateCollection, certificateRecord.CertificateID)
+	if err != nil {
+		fmt.Printf("- private certificate %s not readable: %s\n", certificateRecord.CertificateID, err)
+		return certificateRecordAsBytes, nil
+	} else if bodyAsBytes == nil {
+		return certificateRecordAsBytes, nil
+	}
+	body := &PrivateCertificate{}
+	if err = json.Unmarshal(bodyAsBytes, body); err != nil {
+		return nil, err
+	}
+	certificateRecord.CertificateHash = body.CertificateHash
+	return json.Marshal(certificateRecord)
+}
+
+// isCollectionMember tells if the invoker belongs to a member organization
+// of the private collection
+func isCollectionMember(stub shim.ChaincodeStubInterface) (bool, error) {
+	config, err := getConfig(stub)
+	if err != nil {
+		return false, err
+	}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return false, fmt.Errorf("Failed to get invoker MSP ID: %s", err)
+	}
+	members := config.CollectionMSPIDs
+	if len(members) == 0 && config.AdminMSPID != "" {
+		// stored before the members were configured
+		members = []string{config.AdminMSPID}
+	}
+	for _, member := range members {
+		if mspID == member {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Certificate states. A certificate is active from creation. Suspended
+// certificates can be reinstated, revoked and expired ones cannot change.
+const (
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+	StatusRevoked   = "revoked"
+	StatusExpired   = "expired"
+)
+
+// StatusPending is the effective status of an active certificate before
+// its ValidFrom
+const StatusPending = "pending"
+
+// StatusReasons are the reason codes of the versions written by the status
+// change functions
+var StatusReasons = map[string]bool{
+	"suspension":    true,
+	"reinstatement": true,
+	"revocation":    true,
+	"expiry":        true,
+}
+
+// Validity is the effective status of a certificate at CheckedAt, the
+// transaction timestamp of the query
+type Validity struct {
+	Status    string `json:"Status"`
+	Valid     bool   `json:"Valid"`
+	CheckedAt string `json:"CheckedAt"`
+}
+
+// statusChange is a transition made by one of the status change functions
+type statusChange struct {
+	to     string
+	reason string
+	from   map[string]bool
+}
+
+var (
+	suspension    = statusChange{StatusSuspended, "suspension", map[string]bool{StatusActive: true}}
+	reinstatement = statusChange{StatusActive, "reinstatement", map[string]bool{StatusSuspended: true}}
+	revocation    = statusChange{StatusRevoked, "revocation", map[string]bool{StatusActive: true, StatusSuspended: true}}
+	expiry        = statusChange{StatusExpired, "expiry", map[string]bool{StatusActive: true, StatusSuspended: true}}
+)
+
+// ============================================================
+// suspendCertificate, reinstateCertificate, revokeCertificate
+// and expireCertificate - change the status of a certificate,
+// writing a new version of it
+// args: certificateID
+// ============================================================
+func suspendCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return changeCertificateStatus(stub, args, suspension)
+}
+
+func reinstateCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return changeCertificateStatus(stub, args, reinstatement)
+}
+
+func revokeCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return changeCertificateStatus(stub, args, revocation)
+}
+
+func expireCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return changeCertificateStatus(stub, args, expiry)
+}
+
+func changeCertificateStatus(stub shim.ChaincodeStubInterface, args []string, change statusChange) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("!! Incorrect number of arguments, Expecting 1 !!")
+	}
+
+	key := args[0]
+	fmt.Printf("- start changeCertificateStatus: %s %s\n", key, change.to)
+	certificateRecordAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("Failed to get certificate record: " + err.Error())
+	} else if certificateRecordAsBytes == nil {
+		return shim.Error("Certificate record does not exists: " + key)
+	}
+	certificateRecord := &Certificate{}
+	if err = json.Unmarshal(certificateRecordAsBytes, certificateRecord); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	status := recordStatus(certificateRecord)
+	if !change.from[status] {
+		return shim.Error(fmt.Sprintf("Certificate %s is %s and cannot become %s", key, status, change.to))
+	}
+	authority, err := authorizeInvoker(stub, key, certificateRecord.Category)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	link := chainLink(certificateRecordAsBytes)
+	certificateRecord.Version = recordVersion(certificateRecord) + 1
+	certificateRecord.Reason = change.reason
+	certificateRecord.PreviousHash = certificateRecord.CertificateHash
+	certificateRecord.ChainLink = link
+	certificateRecord.Status = change.to
+	certificateRecord.IssuingAuthority = authority.AuthorityID
+
+	if certificateRecordAsBytes, err = json.Marshal(certificateRecord); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = stub.PutState(key, certificateRecordAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end changeCertificateStatus -")
+	return shim.Success(nil)
+}
+
+// recordStatus is the stored status of a record, records written before
+// statuses count as active
+func recordStatus(certificateRecord *Certificate) string {
+	if certificateRecord.Status == "" {
+		return StatusActive
+	}
+	return certificateRecord.Status
+}
+
+// setValidity fills in the dates and status of a new certificate. validFrom
+// defaults to the issue date and an empty validUntil never expires.
+func setValidity(stub shim.ChaincodeStubInterface, certificateRecord *Certificate, validFrom string, validUntil string) error {
+	issueDate, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	certificateRecord.IssueDate = issueDate
+	certificateRecord.Status = StatusActive
+
+	certificateRecord.ValidFrom = issueDate
+	if validFrom != "" {
+		from, err := time.Parse(time.RFC3339, validFrom)
+		if err != nil {
+			return fmt.Errorf("validFrom must be an RFC 3339 time")
+		}
+		certificateRecord.ValidFrom = from.UTC().Format(time.RFC3339)
+	}
+	if validUntil != "" {
+		until, err := time.Parse(time.RFC3339, validUntil)
+		if err != nil {
+			return fmt.Errorf("validUntil must be an RFC 3339 time")
+		}
+		if from, _ := time.Parse(time.RFC3339, certificateRecord.ValidFrom); !until.After(from) {
+			return fmt.Errorf("validUntil must be after validFrom")
+		}
+		certificateRecord.ValidUntil = until.UTC().Format(time.RFC3339)
+	}
+	return nil
+}
+
+// effectiveValidity is the status of a certificate at the transaction
+// timestamp: an active certificate is pending before ValidFrom and expired
+// after ValidUntil
+func effectiveValidity(stub shim.ChaincodeStubInterface, certificateRecord *Certificate) (*Validity, error) {
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC()
+
+	validity := &Validity{Status: recordStatus(certificateRecord), CheckedAt: now.Format(time.RFC3339)}
+	if validity.Status == StatusActive {
+		if from, err := time.Parse(time.RFC3339, certificateRecord.ValidFrom); err == nil && now.Before(from) {
+			validity.Status = StatusPending
+		} else if until, err := time.Parse(time.RFC3339, certificateRecord.ValidUntil); err == nil && !now.Before(until) {
+			validity.Status = StatusExpired
+		}
+	}
+	validity.Valid = validity.Status == StatusActive
+	return validity, nil
+}
+
+// withValidity adds the effective Validity to a record for output
+func withValidity(stub shim.ChaincodeStubInterface, certificateRecordAsBytes []byte) ([]byte, error) {
+	certificateRecord := &Certificate{}
+	if err := json.Unmarshal(certificateRecordAsBytes, certificateRecord); err != nil {
+		return certificateRecordAsBytes, nil
+	}
+	validity, err := effectiveValidity(stub, certificateRecord)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		*Certificate
+		Validity *Validity `json:"Validity"`
+	}{certificateRecord, validity})
+}
+
+// CertificateStatus is the response of checkCertificateStatus
+type CertificateStatus struct {
+	CertificateID string `json:"CertificateID"`
+	Exists        bool   `json:"Exists"`
+	Status        string `json:"Status,omitempty"` // effective status, see Validity
+	Valid         bool   `json:"Valid"`
+	CheckedAt     string `json:"CheckedAt,omitempty"`
+}
+
+// ============================================================
+// checkCertificateStatus - the effective status of a
+// certificate, for other chaincodes and verifiers. It reveals
+// neither the hash nor the holder, so it needs no access grant,
+// but it does tell any channel member whether an ID exists and
+// is valid, see reader.
+// args: certificateID
+// ============================================================
+func checkCertificateStatus(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("!! Incorrect number of arguments, Expecting 1 !!")
+	}
+
+	result := CertificateStatus{CertificateID: args[0]}
+	certificateRecordAsBytes, err := stub.GetState(args[0])
+	if err != nil {
+		return shim.Error("Failed to get certificate record: " + err.Error())
+	}
+	if certificateRecordAsBytes != nil {
+		certificateRecord := &Certificate{}
+		if err = json.Unmarshal(certificateRecordAsBytes, certificateRecord); err != nil {
+			return shim.Error(err.Error())
+		}
+		validity, err := effectiveValidity(stub, certificateRecord)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Exists = true
+		result.Status = validity.Status
+		result.Valid = validity.Valid
+		result.CheckedAt = validity.CheckedAt
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// AdminRole is the value of the "role" attribute in the enrollment
+// certificate of clients of the admin MSP allowed to run admin operations
+const AdminRole = "admin"
+
+// PersonalDataRoles are the "role" attribute values allowed to read
+// unmasked personal data, for clients of the admin MSP
+var PersonalDataRoles = map[string]bool{
+	AdminRole: true,
+}
+
+// adminConfig names the organization of the admins, stored by Init
+type adminConfig struct {
+	MSPID string `json:"MSPID"`
+}
+
+// isAdmin reports whether the invoker belongs to the admin MSP and carries
+// the role=admin attribute
+func isAdmin(stub shim.ChaincodeStubInterface) bool {
+	return inAdminMSP(stub) && invokerRole(stub) == AdminRole
+}
+
+// canSeePersonalData reports whether the invoker may read Contacts, Mobile
+// and Email unmasked
+func canSeePersonalData(stub shim.ChaincodeStubInterface) bool {
+	return inAdminMSP(stub) && PersonalDataRoles[invokerRole(stub)]
+}
+
+// authorizeIssuerOrAdmin checks that the invoker belongs to the organization
+// that issued certificate, or is an admin. Certificates written before
+// issuers were recorded are left to admins.
+func authorizeIssuerOrAdmin(stub shim.ChaincodeStubInterface, certificate *Certificate, action string) error {
+	if isAdmin(stub) {
+		return nil
+	}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return fmt.Errorf("Failed to get invoker MSP ID: %s", err)
+	}
+	if certificate.Issuer != nil && certificate.Issuer.MSPID == mspID {
+		return nil
+	}
+	return fmt.Errorf("Only the issuing organization or an admin can %s certificate %s", action, certificate.CertificateHash)
+}
+
+// inAdminMSP reports whether the invoker belongs to the admin MSP stored by
+// Init. Any organization can enroll clients with a role attribute, so the
+// role only counts within the admin MSP, and no one is an admin before Init
+// names it.
+func inAdminMSP(stub shim.ChaincodeStubInterface) bool {
+	config, err := getAdminConfig(stub)
+	if err != nil || config.MSPID == "" {
+		return false
+	}
+	mspID, err := cid.GetMSPID(stub)
+	return err == nil && mspID == config.MSPID
+}
+
+// getAdminConfig reads the admin MSP stored by Init, which is empty before
+func getAdminConfig(stub shim.ChaincodeStubInterface) (*adminConfig, error) {
+	configKey, err := stub.CreateCompositeKey("config", []string{"admin"})
+	if err != nil {
+		return nil, err
+	}
+	configAsBytes, err := stub.GetState(configKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get admin config: %s", err)
+	}
+	config := &adminConfig{}
+	if configAsBytes != nil {
+		if err = json.Unmarshal(configAsBytes, config); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+func putAdminConfig(stub shim.ChaincodeStubInterface, config *adminConfig) error {
+	configKey, err := stub.CreateCompositeKey("config", []string{"admin"})
+	if err != nil {
+		return err
+	}
+	configAsBytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(configKey, configAsBytes)
+}
+
+// invokerRole returns the "role" attribute of the invoker, or "" if it has none
+func invokerRole(stub shim.ChaincodeStubInterface) string {
+	role, found, err := cid.GetAttributeValue(stub, "role")
+	if err != nil || !found {
+		return ""
+	}
+	return role
+}
+
+// maskCertificate replaces the personal data of certificate with masked values
+func maskCertificate(certificate *Certificate) {
+	certificate.Contacts = maskName(certificate.Contacts)
+	certificate.Mobile = maskMobile(certificate.Mobile)
+	certificate.Email = maskEmail(certificate.Email)
+
+	// copy the list, it may be shared with an unmasked certificate
+	participants := make(Participants, len(certificate.Participant))
+	for i, participant := range certificate.Participant {
+		participant.Name = maskName(participant.Name)
+		participants[i] = participant
+	}
+	if certificate.Participant != nil {
+		certificate.Participant = participants
+	}
+
+	// and the partner fields kept with a version 1 signature
+	if certificate.Issuer != nil && certificate.Issuer.SignedPartner != nil {
+		issuer := *certificate.Issuer
+		partner := *issuer.SignedPartner
+		partner.Contacts = maskName(partner.Contacts)
+		partner.Mobile = maskMobile(partner.Mobile)
+		partner.Email = maskEmail(partner.Email)
+		issuer.SignedPartner = &partner
+		certificate.Issuer = &issuer
+	}
+}
+
+// certificateRecordBytes returns a stored certificate record for output.
+// If resolve is set the partner fields are filled in from the registry, and
+// if masked is set personal data is masked. Values that are not
+// certificates, which always carry a CertificateHash, are returned as-is.
+func certificateRecordBytes(stub shim.ChaincodeStubInterface, value []byte, masked bool, resolve bool) []byte {
+	if !masked && !resolve {
+		return value
+	}
+	certificate := Certificate{}
+	if err := json.Unmarshal(value, &certificate); err != nil || certificate.CertificateHash == "" {
+		return value
+	}
+	if resolve {
+		if err := resolvePartner(stub, &certificate, nil); err != nil {
+			return value
+		}
+	}
+	if masked {
+		maskCertificate(&certificate)
+	}
+	certificateAsBytes, err := json.Marshal(certificate)
+	if err != nil {
+		return value
+	}
+	return certificateAsBytes
+}
+
+// maskName keeps the first character of a name, e.g. 张** for 张三丰
+func maskName(value string) string {
+	runes := []rune(value)
+	if len(runes) == 0 {
+		return value
+	}
+	return string(runes[0]) + "**"
+}
+
+// maskMobile keeps the first 3 and last 4 digits, e.g. 138****5678
+func maskMobile(value string) string {
+	runes := []rune(value)
+	if len(runes) == 0 {
+		return value
+	}
+	if len(runes) < 8 {
+		return "****"
+	}
+	return string(runes[:3]) + "****" + string(runes[len(runes)-4:])
+}
+
+// maskEmail keeps the first character of the local part and the domain,
+// e.g. a****@example.com
+func maskEmail(value string) string {
+	at := strings.LastIndex(value, "@")
+	if at <= 0 {
+		return maskName(value)
+	}
+	return string([]rune(value[:at])[0]) + "****" + value[at:]
+}
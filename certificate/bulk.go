@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// MaxImportRows limits the number of rows of a single importCertificates call
+const MaxImportRows = 1000
+
+// certificateImportFields are the columns of the import and export formats,
+// in the order of the positional createCertificate arguments. Partners are
+// identified by PartnerName so records can move between ledgers.
+var certificateImportFields = []string{
+	"CertificateHash",
+	"PartnerName",
+	"Contacts",
+	"Mobile",
+	"Email",
+	"CertificateType",
+	"CertificateName",
+	"PassingDate",
+	"ExpiryDate",
+	"CertificateStatus",
+	"Participant",
+	"Score",
+}
+
+// ImportRowResult is the outcome of a single imported row
+type ImportRowResult struct {
+	Row             int    `json:"Row"` // 1-based, not counting the CSV header
+	CertificateHash string `json:"CertificateHash"`
+	Status          string `json:"Status"` // created or failed
+	Error           string `json:"Error,omitempty"`
+}
+
+// ImportResult is the response of importCertificates
+type ImportResult struct {
+	Created int               `json:"Created"`
+	Failed  int               `json:"Failed"`
+	Rows    []ImportRowResult `json:"Rows"`
+}
+
+// importRow is a parsed row, or the error that made it invalid
+type importRow struct {
+	hash        string
+	certificate *Certificate
+	err         error
+}
+
+// ===============================================================================
+// importCertificates - create many certificates in one transaction
+// args: format [json, csv], payload [, allOrNothing]
+// A json payload is an array of Certificate objects, a csv payload has a
+// header row naming the columns and a Participant column holding a JSON list
+// of participants or a single name. Every row is validated and reported on. With
+// allOrNothing set to true the transaction fails if any row fails, otherwise
+// the valid rows are created.
+// ===============================================================================
+func (s *SmartContract) importCertificates(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+	allOrNothing := false
+	if len(args) == 3 {
+		var err error
+		if allOrNothing, err = strconv.ParseBool(args[2]); err != nil {
+			return shim.Error("3rd argument allOrNothing must be true or false")
+		}
+	}
+
+	var rows []importRow
+	var err error
+	switch args[0] {
+	case "json":
+		rows, err = parseJSONImport(args[1])
+	case "csv":
+		rows, err = parseCSVImport(args[1])
+	default:
+		return shim.Error("Incorrect format [json, csv]")
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(rows) > MaxImportRows {
+		return shim.Error(fmt.Sprintf("Too many rows, at most %d can be imported at once", MaxImportRows))
+	}
+
+	// every row is validated before anything is written, so a failed row
+	// leaves no partner or score aggregate behind. GetState does not see the
+	// writes of this transaction, so the hashes and partners of the earlier
+	// valid rows are kept here.
+	pending := partnerCache{}
+	imported := map[string]int{}
+	valid := []*Certificate{}
+
+	result := ImportResult{Rows: []ImportRowResult{}}
+	for i, row := range rows {
+		rowResult := ImportRowResult{Row: i + 1, CertificateHash: row.hash, Status: "created"}
+
+		if err = row.err; err == nil {
+			err = validateImportRow(stub, row.certificate, imported, pending)
+		}
+		if err != nil {
+			rowResult.Status = "failed"
+			rowResult.Error = err.Error()
+			result.Failed++
+		} else {
+			imported[row.certificate.CertificateHash] = i + 1
+			valid = append(valid, row.certificate)
+			result.Created++
+		}
+		result.Rows = append(result.Rows, rowResult)
+	}
+
+	if !allOrNothing || result.Failed == 0 {
+		partners := partnerCache{}
+		batch := newCertificateBatch()
+		for _, certificate := range valid {
+			// a validated row that cannot be written fails the whole transaction
+			if err = linkPartner(stub, certificate, partners); err != nil {
+				return shim.Error(fmt.Sprintf("Failed to import %s: %s", certificate.CertificateHash, err))
+			}
+			if err = issueCertificate(stub, certificate, "", batch); err != nil {
+				return shim.Error(fmt.Sprintf("Failed to import %s: %s", certificate.CertificateHash, err))
+			}
+		}
+		if err = batch.emit(stub); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- importCertificates created %d, failed %d\n", result.Created, result.Failed)
+	if allOrNothing && result.Failed > 0 {
+		return shim.Error(string(resultAsBytes))
+	}
+	return shim.Success(resultAsBytes)
+}
+
+// CertificateExportPage is a page of exportCertificates. Data holds the page
+// in the importCertificates format, pass Bookmark to the next call to get the
+// following page.
+type CertificateExportPage struct {
+	Format   string `json:"Format"`
+	Data     string `json:"Data"`
+	Count    int    `json:"Count"`
+	Bookmark string `json:"Bookmark"`
+}
+
+// ===============================================================================
+// exportCertificates - export a page of certificates in the importCertificates format
+// args: format [json, csv], page size, bookmark [, PartnerName or CertificateName, name]
+// Certificates are ordered by CertificateHash. Personal data is masked as in
+// queries unless the invoker may see it. Only an unmasked export can be
+// imported again.
+// ===============================================================================
+func (s *SmartContract) exportCertificates(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 && len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 3 or 5")
+	}
+	if args[0] != "json" && args[0] != "csv" {
+		return shim.Error("Incorrect format [json, csv]")
+	}
+	pageSize, err := strconv.Atoi(args[1])
+	if err != nil || pageSize <= 0 || pageSize > MaxPageSize {
+		return shim.Error(fmt.Sprintf("2nd argument page size must be a number from 1 to %d", MaxPageSize))
+	}
+
+	var certificates []*Certificate
+	var bookmark string
+	if len(args) == 5 {
+		if _, ok := CerfificationQueryMap[args[3]]; !ok {
+			return shim.Error("Incorrect Query Option [PartnerName, CertificateName]")
+		}
+		certificates, bookmark, err = certificatePageByName(stub, args[3], args[4], pageSize, args[2])
+	} else {
+		certificates, bookmark, err = certificatePage(stub, pageSize, args[2])
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	masked := !canSeePersonalData(stub)
+	cache := partnerCache{}
+	records := []map[string]interface{}{}
+	for _, certificate := range certificates {
+		if err = resolvePartner(stub, certificate, cache); err != nil {
+			return shim.Error(err.Error())
+		}
+		if masked {
+			maskCertificate(certificate)
+		}
+		record, err := certificateImportRecord(certificate)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		records = append(records, record)
+	}
+
+	var exportAsBytes []byte
+	if args[0] == "csv" {
+		exportAsBytes, err = formatCSVExport(records)
+	} else {
+		exportAsBytes, err = json.Marshal(records)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	page := CertificateExportPage{Format: args[0], Data: string(exportAsBytes), Count: len(records), Bookmark: bookmark}
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- exportCertificates exported %d certificates\n", page.Count)
+	return shim.Success(pageAsBytes)
+}
+
+// validateImportRow runs the checks of a single imported certificate without
+// writing anything. imported maps the hashes of the earlier valid rows to
+// their row, pending holds the partners those rows will register.
+func validateImportRow(stub shim.ChaincodeStubInterface, certificate *Certificate, imported map[string]int, pending partnerCache) error {
+	if row, ok := imported[certificate.CertificateHash]; ok {
+		return fmt.Errorf("Duplicate of row %d", row)
+	}
+	existing, err := getCertificate(stub, certificate.CertificateHash)
+	if err != nil {
+		return err
+	} else if existing != nil {
+		return fmt.Errorf("This certificate already exists: %s", certificate.CertificateHash)
+	}
+
+	if err = checkPartnerContacts(stub, certificate, pending); err != nil {
+		return err
+	}
+	if certificate.PartnerID != "" {
+		partner, err := getPartner(stub, certificate.PartnerID, pending)
+		if err != nil {
+			return err
+		} else if partner == nil {
+			return fmt.Errorf("Partner does not exist: %s", certificate.PartnerID)
+		}
+	} else if certificate.PartnerName == "" {
+		return fmt.Errorf("Certificate needs a PartnerID or a PartnerName")
+	} else {
+		partner, err := getPartnerByName(stub, certificate.PartnerName, pending)
+		if err != nil {
+			return err
+		} else if partner == nil {
+			// registered by this row, later rows must match its contacts
+			pending.put(&Partner{
+				PartnerID:   derivePartnerID(certificate.PartnerName),
+				PartnerName: certificate.PartnerName,
+				Contacts:    certificate.Contacts,
+				Mobile:      certificate.Mobile,
+				Email:       certificate.Email,
+			})
+		}
+	}
+
+	if certificate.SocialSecurityID != "" {
+		// the link is kept, issueCertificate does not check it again
+		return linkSocialSecurity(stub, certificate)
+	}
+	return nil
+}
+
+func parseJSONImport(payload string) ([]importRow, error) {
+	var documents []json.RawMessage
+	if err := json.Unmarshal([]byte(payload), &documents); err != nil {
+		return nil, fmt.Errorf("Invalid import payload, expecting a JSON array: %s", err)
+	}
+
+	rows := []importRow{}
+	for _, document := range documents {
+		// the hash is reported even when the row is invalid
+		row := struct {
+			CertificateHash string `json:"CertificateHash"`
+		}{}
+		json.Unmarshal(document, &row)
+
+		certificate, err := certificateFromJSON(string(document))
+		rows = append(rows, importRow{hash: row.CertificateHash, certificate: certificate, err: err})
+	}
+	return rows, nil
+}
+
+func parseCSVImport(payload string) ([]importRow, error) {
+	reader := csv.NewReader(strings.NewReader(payload))
+	// rows with a wrong number of columns are reported on their own
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("Invalid import payload, the CSV header row is missing")
+	} else if err != nil {
+		return nil, fmt.Errorf("Invalid import payload: %s", err)
+	}
+	known := map[string]bool{}
+	for _, name := range certificateImportFields {
+		known[name] = true
+	}
+	for _, name := range header {
+		if !known[name] {
+			return nil, fmt.Errorf("Unknown CSV column: %s", name)
+		}
+	}
+
+	rows := []importRow{}
+	for {
+		values, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Invalid import payload: %s", err)
+		}
+		if len(values) != len(header) {
+			rows = append(rows, importRow{err: fmt.Errorf("Expecting %d columns, found %d", len(header), len(values))})
+			continue
+		}
+
+		record := map[string]interface{}{}
+		hash := ""
+		for i, name := range header {
+			record[name] = values[i]
+			if name == "CertificateHash" {
+				hash = values[i]
+			}
+		}
+		// the Participant column holds a JSON list or a single name
+		if value, ok := record["Participant"].(string); ok {
+			if record["Participant"], err = parseParticipants(value); err != nil {
+				rows = append(rows, importRow{hash: hash, err: err})
+				continue
+			}
+		}
+
+		document, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		certificate, err := certificateFromJSON(string(document))
+		rows = append(rows, importRow{hash: hash, certificate: certificate, err: err})
+	}
+	return rows, nil
+}
+
+// certificateImportRecord projects a certificate on the import fields
+func certificateImportRecord(certificate *Certificate) (map[string]interface{}, error) {
+	document, err := certificateToMap(certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	record := map[string]interface{}{}
+	for _, name := range certificateImportFields {
+		record[name] = document[name]
+		if record[name] == nil {
+			record[name] = ""
+		}
+	}
+	return record, nil
+}
+
+// formatCSVExport writes records as CSV. Values that are not strings, such as
+// the Participant list, are written as JSON.
+func formatCSVExport(records []map[string]interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	if err := writer.Write(certificateImportFields); err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		values := make([]string, len(certificateImportFields))
+		for i, name := range certificateImportFields {
+			if text, ok := record[name].(string); ok {
+				values[i] = text
+				continue
+			}
+			valueAsBytes, err := json.Marshal(record[name])
+			if err != nil {
+				return nil, err
+			}
+			values[i] = string(valueAsBytes)
+		}
+		if err := writer.Write(values); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
+
+// certificatePage reads a page of certificate records in CertificateHash
+// order. Keys in the range that are not certificates are skipped, so a page
+// may hold fewer than pageSize records.
+func certificatePage(stub shim.ChaincodeStubInterface, pageSize int, bookmark string) ([]*Certificate, string, error) {
+	resultsIterator, metadata, err := stub.GetStateByRangeWithPagination("", "", int32(pageSize), bookmark)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resultsIterator.Close()
+
+	certificates := []*Certificate{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, "", err
+		}
+		certificate := &Certificate{}
+		if err = json.Unmarshal(queryResponse.Value, certificate); err != nil || certificate.CertificateHash != queryResponse.Key {
+			// not a certificate record
+			continue
+		}
+		certificates = append(certificates, certificate)
+	}
+	return certificates, metadata.Bookmark, nil
+}
+
+// certificatePageByName reads a page of the certificates of a PartnerName or
+// CertificateName in CertificateHash order. The bookmark is the last hash of
+// the previous page.
+func certificatePageByName(stub shim.ChaincodeStubInterface, option string, name string, pageSize int, bookmark string) ([]*Certificate, string, error) {
+	hashes, err := certificateHashesByName(stub, option, name)
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Strings(hashes)
+
+	certificates := []*Certificate{}
+	next := ""
+	for _, hash := range hashes {
+		if hash <= bookmark {
+			continue
+		}
+		if len(certificates) == pageSize {
+			next = certificates[len(certificates)-1].CertificateHash
+			break
+		}
+		certificate, err := getCertificate(stub, hash)
+		if err != nil {
+			return nil, "", err
+		}
+		if certificate != nil {
+			certificates = append(certificates, certificate)
+		}
+	}
+	return certificates, next, nil
+}
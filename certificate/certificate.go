@@ -14,18 +14,28 @@ import (
 type SmartContract struct {
 }
 type Certificate struct {
-	CertificateHash   string `json:CertificateHash`
-	PartnerName       string `json:PartnerName`
-	Contacts          string `json:Contacts`
-	Mobile            string `json:Mobile`
-	Email             string `json:Email`
-	CertificateType   string `json:CertificateType`
-	CertificateName   string `json:CertificateName`
-	PassingDate       string `json:PassingDate`
-	ExpiryDate        string `json:ExpiryDate`
-	CertificateStatus string `json:CertificateStatus` // 0:通过 1:失败 2:降级通过 3:取消
-	Participant       string `json: Participant`
-	Score             string `json: Score`
+	SchemaVersion      int                 `json:"SchemaVersion"` // see CertificateSchemaVersion, records without it are version 1
+	CertificateHash    string              `json:"CertificateHash"`
+	PartnerID          string              `json:"PartnerID,omitempty"`
+	PartnerName        string              `json:"PartnerName"` // PartnerName to Email are filled in from the partner registry on output
+	Contacts           string              `json:"Contacts"`
+	Mobile             string              `json:"Mobile"`
+	Email              string              `json:"Email"`
+	CertificateType    string              `json:"CertificateType"`
+	CertificateName    string              `json:"CertificateName"`
+	PassingDate        string              `json:"PassingDate"`
+	ExpiryDate         string              `json:"ExpiryDate"`
+	CertificateStatus  string              `json:"CertificateStatus"` // 0:通过 1:失败 2:降级通过 3:取消
+	Participant        Participants        `json:"Participant"`
+	Score              string              `json:"Score"`
+	ChangedFields      []string            `json:"ChangedFields,omitempty"`
+	Issuer             *Issuer             `json:"Issuer,omitempty"`
+	Supersedes         string              `json:"Supersedes,omitempty"`   // hash of the certificate this one renews
+	SupersededBy       string              `json:"SupersededBy,omitempty"` // hash of the renewal of this certificate
+	Revocation         *Revocation         `json:"Revocation,omitempty"`
+	LastTxID           string              `json:"LastTxID,omitempty"`         // transaction that last wrote the record
+	SocialSecurityID   string              `json:"SocialSecurityID,omitempty"` // linked certificate of the SocialSecurity chaincode
+	SocialSecurityLink *SocialSecurityLink `json:"SocialSecurityLink,omitempty"`
 }
 
 var CerfificationQueryMap = map[string]string{
@@ -39,7 +49,33 @@ func main() {
 		fmt.Printf("Error starting transaction Trace chaincode: %s", err)
 	}
 }
+
+// Init takes the MSP ID of the admins, see isAdmin, optionally followed by
+// the name of the SocialSecurity chaincode and its channel, used to check
+// linked social-security certificates. Without arguments, as on an upgrade,
+// the stored configuration is kept.
 func (s *SmartContract) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	if len(args) > 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 0 to 3")
+	}
+	if len(args) > 0 {
+		if len(args[0]) <= 0 {
+			return shim.Error("1st argument adminMSPID must be a non-empty string")
+		}
+		if err := putAdminConfig(stub, &adminConfig{MSPID: args[0]}); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if len(args) > 1 {
+		config := &socialSecurityConfig{Chaincode: args[1]}
+		if len(args) == 3 {
+			config.Channel = args[2]
+		}
+		if err := putSocialSecurityConfig(stub, config); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 
 	return shim.Success(nil)
 
@@ -58,14 +94,54 @@ func (s *SmartContract) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 		return s.getHistoryForRecord(stub, args)
 	} else if function == "queryCertificate" {
 		return s.queryCertificate(stub, args)
-	} else if function == "removeCertificate" {
-		return s.removeCertificate(stub, args)
+	} else if function == "purgeCertificate" || function == "removeCertificate" {
+		return s.purgeCertificate(stub, args)
 	} else if function == "updateCertificate" {
 		return s.updateCertificate(stub, args)
+	} else if function == "patchCertificate" {
+		return s.patchCertificate(stub, args)
+	} else if function == "verifyIssuerSignature" {
+		return s.verifyIssuerSignature(stub, args)
+	} else if function == "renewCertificate" {
+		return s.renewCertificate(stub, args)
+	} else if function == "queryCertificateLineage" {
+		return s.queryCertificateLineage(stub, args)
+	} else if function == "revokeCertificate" {
+		return s.revokeCertificate(stub, args)
+	} else if function == "queryRevokedSince" {
+		return s.queryRevokedSince(stub, args)
 	} else if function == "queryCertificateBasedOnName" {
 		return s.queryCertificateBasedOnName(stub, args)
 	} else if function == "queryAllCertificate" {
 		return s.queryAllCertificate(stub, args)
+	} else if function == "reindexCertificates" {
+		return s.reindexCertificates(stub, args)
+	} else if function == "createPartner" {
+		return s.createPartner(stub, args)
+	} else if function == "updatePartner" {
+		return s.updatePartner(stub, args)
+	} else if function == "queryPartner" {
+		return s.queryPartner(stub, args)
+	} else if function == "migratePartners" {
+		return s.migratePartners(stub, args)
+	} else if function == "queryScoreStatistics" {
+		return s.queryScoreStatistics(stub, args)
+	} else if function == "rebuildScoreStatistics" {
+		return s.rebuildScoreStatistics(stub, args)
+	} else if function == "importCertificates" {
+		return s.importCertificates(stub, args)
+	} else if function == "exportCertificates" {
+		return s.exportCertificates(stub, args)
+	} else if function == "migrateCertificates" {
+		return s.migrateCertificates(stub, args)
+	} else if function == "queryCertificatesByParticipant" {
+		return s.queryCertificatesByParticipant(stub, args)
+	} else if function == "exportCredential" {
+		return s.exportCredential(stub, args)
+	} else if function == "publicVerify" {
+		return s.publicVerify(stub, args)
+	} else if function == "queryRevokedSocialSecurityLinks" {
+		return s.queryRevokedSocialSecurityLinks(stub, args)
 	}
 
 	return shim.Error("Invalid Smart Contract function name." + function)
@@ -87,7 +163,13 @@ func (s *SmartContract) queryCertificate(stub shim.ChaincodeStubInterface, args
 
 	json.Unmarshal(certificateAsBytes, &certificate)
 
-	// Normally check that the specified argument is a valid holder of tuna but here we are skipping this check for this example.
+	if err := resolvePartner(stub, &certificate, nil); err != nil {
+		return shim.Error(err.Error())
+	}
+	// hide personal data from callers without access to it
+	if !canSeePersonalData(stub) {
+		maskCertificate(&certificate)
+	}
 
 	certificateAsBytes, _ = json.Marshal(certificate)
 
@@ -103,42 +185,97 @@ func (s *SmartContract) queryCertificate(stub shim.ChaincodeStubInterface, args
 }
 func (s *SmartContract) createCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	if len(args) != 12 {
-		return shim.Error("Incorrect number of arguments. Expecting 12")
-	}
+	var certificate *Certificate
+	var signature string
+	var err error
 
-	key := args[0]
+	if len(args) == 1 || len(args) == 2 {
+		// a single JSON Certificate object, optionally followed by the issuer signature
+		certificate, err = certificateFromJSON(args[0])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if len(args) == 2 {
+			signature = args[1]
+		}
+	} else if len(args) == 12 || len(args) == 13 {
+		var participants Participants
+		if participants, err = parseParticipants(args[10]); err != nil {
+			return shim.Error(err.Error())
+		}
+		certificate = &Certificate{CertificateHash: args[0], PartnerName: args[1], Contacts: args[2], Mobile: args[3], Email: args[4], CertificateType: args[5], CertificateName: args[6], PassingDate: args[7], ExpiryDate: args[8], CertificateStatus: args[9], Participant: participants, Score: args[11]}
+		if err = validateParticipants(participants); err != nil {
+			return shim.Error(err.Error())
+		}
+		if err = validateScore(args[11]); err != nil {
+			return shim.Error("12th argument Score " + err.Error())
+		}
+		if len(args) == 13 {
+			certificate.SocialSecurityID = args[12]
+		}
+	} else {
+		return shim.Error("Incorrect number of arguments. Expecting 1 JSON Certificate, optionally with a signature, or 12, optionally with a social-security certificate ID")
+	}
 
-	keyAsBytes, _ := stub.GetState(key)
-	if keyAsBytes != nil {
-		return shim.Error("Certificate key already exists:" + key)
+	if err = checkPartnerContacts(stub, certificate, nil); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = linkPartner(stub, certificate, nil); err != nil {
+		return shim.Error(err.Error())
+	}
 
+	err = issueCertificate(stub, certificate, signature, nil)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	var certificate = Certificate{CertificateHash: args[0], PartnerName: args[1], Contacts: args[2], Mobile: args[3], Email: args[4], CertificateType: args[5], CertificateName: args[6], PassingDate: args[7], ExpiryDate: args[8], CertificateStatus: args[9], Participant: args[10], Score: args[11]}
+	return shim.Success(nil)
+}
 
-	certificateAsBytes, _ := json.Marshal(certificate)
+// issueCertificate stores a new certificate issued by the invoker. signature
+// is the optional detached issuer signature over the canonical record. The
+// certificate must already be linked to its partner.
+func issueCertificate(stub shim.ChaincodeStubInterface, certificate *Certificate, signature string, batch *certificateBatch) error {
+	key := certificate.CertificateHash
 
-	err := stub.PutState(args[0], certificateAsBytes)
-	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to record certificate catch: %s", args[0]))
+	keyAsBytes, _ := stub.GetState(key)
+	if keyAsBytes != nil {
+		return fmt.Errorf("Certificate key already exists:%s", key)
 	}
 
-	// create index
-	err = createIndexHelper(stub, &certificate)
+	// record who issued the certificate
+	var err error
+	certificate.Issuer, err = newIssuer(stub)
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
+	}
+	if signature != "" {
+		if err = verifySignature(certificate.Issuer, certificate, signature); err != nil {
+			return fmt.Errorf("Invalid issuer signature: %s", err)
+		}
+		certificate.Issuer.Signature = signature
+	}
+	if certificate.SocialSecurityID != "" && certificate.SocialSecurityLink == nil {
+		if err = linkSocialSecurity(stub, certificate); err != nil {
+			return err
+		}
 	}
 
-	return shim.Success(nil)
+	return storeCertificate(stub, nil, certificate, batch)
 }
 
-func (s *SmartContract) removeCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+// purgeCertificate hard deletes a certificate record and is limited to admins.
+// Use revokeCertificate to withdraw a certificate and keep it verifiable.
+func (s *SmartContract) purgeCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
 	if len(args) != 1 {
 		return shim.Error("Incorrect number of arguments. Expecting 1")
 	}
 
+	if !isAdmin(stub) {
+		return shim.Error("Only an admin can purge a certificate")
+	}
+
 	certificateAsBytes, err := stub.GetState(args[0])
 	if err != nil {
 		return shim.Error("Failed to get Certificate record: " + err.Error())
@@ -161,6 +298,19 @@ func (s *SmartContract) removeCertificate(stub shim.ChaincodeStubInterface, args
 		return shim.Error(err.Error())
 	}
 
+	err = updateScoreStatistics(stub, certificate, nil, nil)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	event, err := newCertificateEvent(stub, certificate, nil)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = setCertificateEvents(stub, []CertificateEvent{event}); err != nil {
+		return shim.Error(err.Error())
+	}
+
 	return shim.Success(nil)
 }
 
@@ -175,14 +325,13 @@ func (s *SmartContract) updateCertificate(stub shim.ChaincodeStubInterface, args
 		return shim.Error("Could not locate certificateAsBytes by" + args[0])
 	}
 
-	certificate := Certificate{}
-	json.Unmarshal(certificateAsBytes, &certificate)
-	// delete index
-	err := deleteIndexHelper(stub, &certificate)
-	if err != nil {
-		return shim.Error(err.Error())
+	previous := Certificate{}
+	json.Unmarshal(certificateAsBytes, &previous)
+	if previous.Revocation != nil {
+		return shim.Error("Certificate is revoked: " + args[0])
 	}
 
+	certificate := previous
 	certificate.PartnerName = args[1]
 	certificate.Contacts = args[2]
 	certificate.Mobile = args[3]
@@ -192,17 +341,38 @@ func (s *SmartContract) updateCertificate(stub shim.ChaincodeStubInterface, args
 	certificate.PassingDate = args[7]
 	certificate.ExpiryDate = args[8]
 	certificate.CertificateStatus = args[9]
-	certificate.Participant = args[10]
+	participants, err := parseParticipants(args[10])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = validateParticipants(participants); err != nil {
+		return shim.Error(err.Error())
+	}
+	certificate.Participant = participants
+	if err = validateScore(args[11]); err != nil {
+		return shim.Error("12th argument Score " + err.Error())
+	}
 	certificate.Score = args[11]
 
-	certificateAsBytes, _ = json.Marshal(certificate)
-	err = stub.PutState(args[0], certificateAsBytes)
+	// the positional form names the partner, its contacts must match the registry
+	certificate.PartnerID = ""
+	if err = checkPartnerContacts(stub, &certificate, nil); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = linkPartner(stub, &certificate, nil); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	changedFields, err := diffCertificateFields(&previous, &certificate)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to update certificate: %s", args[0]))
+		return shim.Error(err.Error())
+	}
+	certificate.ChangedFields = changedFields
+	if err = reissueCertificate(stub, &previous, &certificate); err != nil {
+		return shim.Error(err.Error())
 	}
 
-	// create index
-	err = createIndexHelper(stub, &certificate)
+	err = storeCertificate(stub, &previous, &certificate, nil)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -215,6 +385,13 @@ func (t *SmartContract) getHistoryForRecord(stub shim.ChaincodeStubInterface, ar
 	if len(args) < 1 {
 		return shim.Error("Incorrect number of arguments. Expecting 1")
 	}
+	if len(args) > 1 {
+		// getHistoryForRecord key, mode, ...
+		if args[1] != "diff" {
+			return shim.Error("Incorrect history mode [diff]")
+		}
+		return t.getHistoryDiff(stub, args[0], args[2:])
+	}
 
 	recordKey := args[0]
 	fmt.Printf("- start getHistoryForRecord: %s\n", recordKey)
@@ -225,6 +402,8 @@ func (t *SmartContract) getHistoryForRecord(stub shim.ChaincodeStubInterface, ar
 	}
 	defer resultsIterator.Close()
 
+	masked := !canSeePersonalData(stub)
+
 	// buffer is a JSON array containing historic values for the key/value pair
 	var buffer bytes.Buffer
 	buffer.WriteString("[")
@@ -251,7 +430,7 @@ func (t *SmartContract) getHistoryForRecord(stub shim.ChaincodeStubInterface, ar
 		if response.IsDelete {
 			buffer.WriteString("null")
 		} else {
-			buffer.WriteString(string(response.Value))
+			buffer.Write(certificateRecordBytes(stub, response.Value, masked, false))
 		}
 
 		buffer.WriteString(", \"Timestamp\":")
@@ -274,7 +453,12 @@ func (t *SmartContract) getHistoryForRecord(stub shim.ChaincodeStubInterface, ar
 	return shim.Success(buffer.Bytes())
 }
 
+// queryAllCertificate returns every key of the namespace. Called with a page
+// size and bookmark it returns a page of certificate records instead.
 func (s *SmartContract) queryAllCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) > 0 {
+		return s.queryCertificatePage(stub, args)
+	}
 
 	startKey := ""
 	endKey := ""
@@ -285,6 +469,8 @@ func (s *SmartContract) queryAllCertificate(stub shim.ChaincodeStubInterface, ar
 	}
 	defer resultsIterator.Close()
 
+	masked := !canSeePersonalData(stub)
+
 	// buffer is a JSON array containing QueryResults
 	var buffer bytes.Buffer
 
@@ -306,8 +492,8 @@ func (s *SmartContract) queryAllCertificate(stub shim.ChaincodeStubInterface, ar
 		buffer.WriteString("\"")
 		buffer.WriteString(", \"Record\":")
 
-		// Record is a JSON object, so we write as-is
-		buffer.WriteString(string(queryResponse.Value))
+		// Record is a JSON object, so we write it with personal data masked
+		buffer.Write(certificateRecordBytes(stub, queryResponse.Value, masked, true))
 		buffer.WriteString("}")
 		bArrayMemberAlreadyWritten = true
 	}
@@ -329,134 +515,36 @@ func (s *SmartContract) queryCertificateBasedOnName(stub shim.ChaincodeStubInter
 		return shim.Error("Incorrect Query Option [PartnerName, CertificateName]")
 	}
 
-	certificateResultsIterator, err := stub.GetStateByPartialCompositeKey(CerfificationQueryMap[args[0]], []string{queryName})
+	hashes, err := certificateHashesByName(stub, args[0], queryName)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	defer certificateResultsIterator.Close()
+
+	masked := !canSeePersonalData(stub)
 
 	var buffer bytes.Buffer
-	var buffer1 bytes.Buffer
 	buffer.WriteString("[")
 
 	bArrayMemberAlreadyWritten := false
-	var i int
-	for i = 0; certificateResultsIterator.HasNext(); i++ {
-		buffer1.Reset()
-		responseRange, err := certificateResultsIterator.Next()
+	for _, returnedCertificateHash := range hashes {
+		certificateAsBytes, err := stub.GetState(returnedCertificateHash)
 		if err != nil {
 			return shim.Error(err.Error())
+		} else if certificateAsBytes == nil {
+			// stale index entry
+			continue
 		}
 
-		objectType, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
-		if err != nil {
-			return shim.Error(err.Error())
-		}
 		if bArrayMemberAlreadyWritten == true {
 			buffer.WriteString(",")
 		}
-
-		var returnedPartnerName, returnedCertificateName string
-		if args[0] == "PartnerName" {
-			returnedPartnerName = compositeKeyParts[0]
-			//returnedCertificateHash := compositeKeyParts[2]
-			//returnedContacts := compositeKeyParts[3]
-			//returnedMobile := compositeKeyParts[4]
-			//returnedEmail := compositeKeyParts[5]
-			//returnedCertificateType := compositeKeyParts[6]
-			returnedCertificateName = compositeKeyParts[1]
-			//returnedPassingDate := compositeKeyParts[7]
-			//returnedExpiryDate := compositeKeyParts[8]
-			//returnedCertificateStatus := compositeKeyParts[9]
-			//returnedParticipant := compositeKeyParts[10]
-			//returnedScore := compositeKeyParts[11]
-
-			fmt.Printf("- found a certificate record from index:%s partnerName:%s certificateName:%s certificateHash:%s contacts:%s mobile:%s email:%s certificateType:%s passingDate:%s expiryDate:%s certificateStatus:%s participant:%s score:%s\n",
-				objectType, compositeKeyParts[0], compositeKeyParts[1],
-				compositeKeyParts[2], compositeKeyParts[3], compositeKeyParts[4], compositeKeyParts[5],
-				compositeKeyParts[6], compositeKeyParts[7], compositeKeyParts[8],
-				compositeKeyParts[9], compositeKeyParts[10], compositeKeyParts[11])
-		} else if args[0] == "CertificateName" {
-			returnedPartnerName = compositeKeyParts[1]
-			returnedCertificateName = compositeKeyParts[0]
-
-			fmt.Printf("- found a certificate record from index:%s certificateName:%s partnerName:%s certificateHash:%s contacts:%s mobile:%s email:%s certificateType:%s passingDate:%s expiryDate:%s certificateStatus:%s participant:%s score:%s\n",
-				objectType, compositeKeyParts[0], compositeKeyParts[1],
-				compositeKeyParts[2], compositeKeyParts[3], compositeKeyParts[4], compositeKeyParts[5],
-				compositeKeyParts[6], compositeKeyParts[7], compositeKeyParts[8],
-				compositeKeyParts[9], compositeKeyParts[10], compositeKeyParts[11])
-		}
-
-		buffer1.WriteString("{\"CertificateHash\":")
-		buffer1.WriteString("\"")
-		buffer1.WriteString(compositeKeyParts[2])
-		buffer1.WriteString("\"")
-
-		buffer1.WriteString(", \"PartnerName\":")
-		buffer1.WriteString("\"")
-		buffer1.WriteString(returnedPartnerName)
-		buffer1.WriteString("\"")
-
-		buffer1.WriteString(", \"Contacts\":")
-		buffer1.WriteString("\"")
-		buffer1.WriteString(compositeKeyParts[3])
-		buffer1.WriteString("\"")
-
-		buffer1.WriteString(", \"Mobile\":")
-		buffer1.WriteString("\"")
-		buffer1.WriteString(compositeKeyParts[4])
-		buffer1.WriteString("\"")
-
-		buffer1.WriteString(", \"Email\":")
-		buffer1.WriteString("\"")
-		buffer1.WriteString(compositeKeyParts[5])
-		buffer1.WriteString("\"")
-
-		buffer1.WriteString(", \"CertificateType\":")
-		buffer1.WriteString("\"")
-		buffer1.WriteString(compositeKeyParts[6])
-		buffer1.WriteString("\"")
-
-		buffer1.WriteString(", \"CertificateName\":")
-		buffer1.WriteString("\"")
-		buffer1.WriteString(returnedCertificateName)
-		buffer1.WriteString("\"")
-
-		buffer1.WriteString(", \"PassingDate\":")
-		buffer1.WriteString("\"")
-		buffer1.WriteString(compositeKeyParts[7])
-		buffer1.WriteString("\"")
-
-		buffer1.WriteString(", \"ExpiryDate\":")
-		buffer1.WriteString("\"")
-		buffer1.WriteString(compositeKeyParts[8])
-		buffer1.WriteString("\"")
-
-		buffer1.WriteString(", \"CertificateStatus\":")
-		buffer1.WriteString("\"")
-		buffer1.WriteString(compositeKeyParts[9])
-		buffer1.WriteString("\"")
-
-		buffer1.WriteString(", \"Participant\":")
-		buffer1.WriteString("\"")
-		buffer1.WriteString(compositeKeyParts[10])
-		buffer1.WriteString("\"")
-
-		buffer1.WriteString(", \"Score\":")
-		buffer1.WriteString("\"")
-		buffer1.WriteString(compositeKeyParts[11])
-		buffer1.WriteString("\"")
-		buffer1.WriteString("}")
-
 		buffer.WriteString("{\"Key\":")
 		buffer.WriteString("\"")
-		buffer.WriteString(compositeKeyParts[2])
+		buffer.WriteString(returnedCertificateHash)
 		buffer.WriteString("\"")
 
 		buffer.WriteString(", \"Record\":")
-		//buffer.WriteString("\"")
-		buffer.WriteString(buffer1.String())
-		//buffer.WriteString("\"")
+		buffer.Write(certificateRecordBytes(stub, certificateAsBytes, masked, true))
 		buffer.WriteString("}")
 
 		bArrayMemberAlreadyWritten = true
@@ -467,18 +555,174 @@ func (s *SmartContract) queryCertificateBasedOnName(stub shim.ChaincodeStubInter
 	return shim.Success(buffer.Bytes())
 }
 
-func createIndexHelper(stub shim.ChaincodeStubInterface, certificate *Certificate) error {
-	var err error = nil
+// certificateHashesByName returns the hashes of the certificates indexed under
+// a PartnerName or CertificateName, without duplicates
+func certificateHashesByName(stub shim.ChaincodeStubInterface, option string, name string) ([]string, error) {
+	// the index entries to look at, partners are resolved through the registry
+	queries := [][]string{{CerfificationQueryMap[option], name}}
+	if option == "PartnerName" {
+		partner, err := getPartnerByName(stub, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		if partner != nil {
+			queries = append(queries, []string{PartnerIDIndexName, partner.PartnerID})
+		}
+	}
+
+	hashes := []string{}
+	returnedHashes := map[string]bool{}
+	for _, query := range queries {
+		certificateResultsIterator, err := stub.GetStateByPartialCompositeKey(query[0], query[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		for certificateResultsIterator.HasNext() {
+			responseRange, err := certificateResultsIterator.Next()
+			if err != nil {
+				certificateResultsIterator.Close()
+				return nil, err
+			}
+
+			objectType, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+			if err != nil {
+				certificateResultsIterator.Close()
+				return nil, err
+			}
+
+			// the hash is the 3rd attribute of every index layout
+			returnedCertificateHash := compositeKeyParts[2]
+			if returnedHashes[returnedCertificateHash] {
+				continue
+			}
+			fmt.Printf("- found a certificate record from index:%s %s:%s certificateHash:%s\n",
+				objectType, option, compositeKeyParts[0], returnedCertificateHash)
+			returnedHashes[returnedCertificateHash] = true
+			hashes = append(hashes, returnedCertificateHash)
+		}
+		certificateResultsIterator.Close()
+	}
+	return hashes, nil
+}
+
+// getCertificate reads a certificate record, returning nil if it does not exist
+func getCertificate(stub shim.ChaincodeStubInterface, key string) (*Certificate, error) {
+	certificateAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get Certificate record: %s", err)
+	} else if certificateAsBytes == nil {
+		return nil, nil
+	}
+
+	certificate := &Certificate{}
+	if err = json.Unmarshal(certificateAsBytes, certificate); err != nil {
+		return nil, err
+	}
+	return certificate, nil
+}
+
+// storeCertificate writes certificate to the ledger and rebuilds its search index.
+// The record is written in the current schema version, so it must already be
+// linked to its partner.
+// previous is the version being replaced, or nil for a new record. batch is
+// shared by the writes of a transaction that writes several certificates,
+// which must emit its events once done. It is nil for a single write, whose
+// event is emitted right away.
+func storeCertificate(stub shim.ChaincodeStubInterface, previous *Certificate, certificate *Certificate, batch *certificateBatch) error {
+	if previous != nil {
+		// delete index
+		if err := deleteIndexHelper(stub, previous); err != nil {
+			return err
+		}
+	}
+
+	var stats scoreCache
+	if batch != nil {
+		stats = batch.stats
+	}
+	if err := updateScoreStatistics(stub, previous, certificate, stats); err != nil {
+		return err
+	}
+
+	certificate.SchemaVersion = CertificateSchemaVersion
+	certificate.LastTxID = stub.GetTxID()
+	certificateAsBytes, err := json.Marshal(certificate)
+	if err != nil {
+		return err
+	}
+	if err = stub.PutState(certificate.CertificateHash, certificateAsBytes); err != nil {
+		return fmt.Errorf("Failed to record certificate catch: %s", certificate.CertificateHash)
+	}
 
-	for queryKey, indexName := range CerfificationQueryMap {
-		if queryKey == "PartnerName" {
-			err = createIndex(stub, indexName, []string{certificate.PartnerName, certificate.CertificateName, certificate.CertificateHash, certificate.Contacts, certificate.Mobile, certificate.Email, certificate.CertificateType, certificate.PassingDate, certificate.ExpiryDate, certificate.CertificateStatus, certificate.Participant, certificate.Score})
-		} else if queryKey == "CertificateName" {
-			err = createIndex(stub, indexName, []string{certificate.CertificateName, certificate.PartnerName, certificate.CertificateHash, certificate.Contacts, certificate.Mobile, certificate.Email, certificate.CertificateType, certificate.PassingDate, certificate.ExpiryDate, certificate.CertificateStatus, certificate.Participant, certificate.Score})
+	// create index
+	if err = createIndexHelper(stub, certificate); err != nil {
+		return err
+	}
+
+	event, err := newCertificateEvent(stub, previous, certificate)
+	if err != nil {
+		return err
+	}
+	if batch != nil {
+		batch.events = append(batch.events, event)
+		return nil
+	}
+	return setCertificateEvents(stub, []CertificateEvent{event})
+}
+
+// txTimestamp returns the transaction timestamp, which is the same on every endorser
+func txTimestamp(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC(), nil
+}
+
+func createIndexHelper(stub shim.ChaincodeStubInterface, certificate *Certificate) error {
+	for _, entry := range certificateIndexEntries(certificate) {
+		if err := createIndex(stub, entry.indexName, entry.attributes); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// indexEntry is a single search index entry of a certificate
+type indexEntry struct {
+	indexName  string
+	attributes []string
+}
+
+// certificateIndexEntries returns the index entries of certificate
+func certificateIndexEntries(certificate *Certificate) []indexEntry {
+	entries := []indexEntry{}
+
+	partner := certificate.PartnerID
+	if partner != "" {
+		entries = append(entries, indexEntry{PartnerIDIndexName, []string{certificate.PartnerID, certificate.CertificateName, certificate.CertificateHash}})
+	} else {
+		// not migrated to the partner registry yet
+		partner = certificate.PartnerName
+		entries = append(entries, indexEntry{CerfificationQueryMap["PartnerName"], []string{certificate.PartnerName, certificate.CertificateName, certificate.CertificateHash}})
+	}
+	entries = append(entries, indexEntry{CerfificationQueryMap["CertificateName"], []string{certificate.CertificateName, partner, certificate.CertificateHash}})
 
-	return err
+	if certificate.Revocation != nil {
+		entries = append(entries, indexEntry{RevocationIndexName, []string{certificate.Revocation.RevokedAt, certificate.CertificateHash}})
+	}
+	entries = append(entries, indexEntry{CertificateSortIndexMap["PassingDate"], []string{sortableDate(certificate.PassingDate), certificate.CertificateHash}})
+	entries = append(entries, indexEntry{CertificateSortIndexMap["ExpiryDate"], []string{sortableDate(certificate.ExpiryDate), certificate.CertificateHash}})
+	for _, participant := range certificate.Participant {
+		if participant.EmployeeID != "" {
+			entries = append(entries, indexEntry{ParticipantIndexName, []string{participant.EmployeeID, certificate.CertificateHash}})
+		}
+	}
+	if certificate.SocialSecurityID != "" {
+		entries = append(entries, indexEntry{SocialSecurityIndexName, []string{certificate.SocialSecurityID, certificate.CertificateHash}})
+	}
+	return entries
 }
 
 // ===============================================
@@ -501,17 +745,69 @@ func createIndex(stub shim.ChaincodeStubInterface, indexName string, attributes
 }
 
 func deleteIndexHelper(stub shim.ChaincodeStubInterface, certificate *Certificate) error {
-	var err error = nil
+	for _, entry := range certificateIndexEntries(certificate) {
+		if err := deleteIndex(stub, entry.indexName, entry.attributes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ===============================================================================
+// reindexCertificates - rebuild the PartnerName and CertificateName indexes
+// Entries in the old layout, which copied every certificate field into the
+// key, are replaced by entries holding only the names and the hash. Admin only.
+// ===============================================================================
+func (s *SmartContract) reindexCertificates(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("Only an admin can rebuild the indexes")
+	}
+
+	reindexed := 0
+	for _, indexName := range CerfificationQueryMap {
+		resultsIterator, err := stub.GetStateByPartialCompositeKey(indexName, []string{})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 
-	for queryKey, indexName := range CerfificationQueryMap {
-		if queryKey == "PartnerName" {
-			err = deleteIndex(stub, indexName, []string{certificate.PartnerName, certificate.CertificateName, certificate.CertificateHash, certificate.Contacts, certificate.Mobile, certificate.Email, certificate.CertificateType, certificate.PassingDate, certificate.ExpiryDate, certificate.CertificateStatus, certificate.Participant, certificate.Score})
-		} else if queryKey == "CertificateName" {
-			err = deleteIndex(stub, indexName, []string{certificate.CertificateName, certificate.PartnerName, certificate.CertificateHash, certificate.Contacts, certificate.Mobile, certificate.Email, certificate.CertificateType, certificate.PassingDate, certificate.ExpiryDate, certificate.CertificateStatus, certificate.Participant, certificate.Score})
+		for resultsIterator.HasNext() {
+			responseRange, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return shim.Error(err.Error())
+			}
+			_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+			if err != nil {
+				resultsIterator.Close()
+				return shim.Error(err.Error())
+			}
+			if len(compositeKeyParts) == 3 {
+				continue
+			}
+
+			// old layout entry, replace it
+			if err = stub.DelState(responseRange.Key); err != nil {
+				resultsIterator.Close()
+				return shim.Error(err.Error())
+			}
+			certificate, err := getCertificate(stub, compositeKeyParts[2])
+			if err != nil {
+				resultsIterator.Close()
+				return shim.Error(err.Error())
+			}
+			if certificate != nil {
+				if err = createIndexHelper(stub, certificate); err != nil {
+					resultsIterator.Close()
+					return shim.Error(err.Error())
+				}
+			}
+			reindexed++
 		}
+		resultsIterator.Close()
 	}
 
-	return err
+	fmt.Printf("- reindexCertificates replaced %d index entries\n", reindexed)
+	return shim.Success([]byte(strconv.Itoa(reindexed)))
 }
 
 func deleteIndex(stub shim.ChaincodeStubInterface, indexName string, attributes []string) error {
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// CredentialContext maps the terms of the credentialSubject and
+// credentialStatus that are not part of the W3C credentials vocabulary
+var CredentialContext = map[string]interface{}{
+	"@vocab": "urn:fabric:certificate#",
+}
+
+// CredentialType is the type of the credentials rendered by exportCredential,
+// as described by schema/certificate-credential.schema.json
+const CredentialType = "PartnerCertificationCredential"
+
+// VerifiableCredential is a W3C Verifiable Credential of a Certificate
+type VerifiableCredential struct {
+	Context           []interface{}     `json:"@context"`
+	ID                string            `json:"id"`
+	Type              []string          `json:"type"`
+	Issuer            CredentialIssuer  `json:"issuer"`
+	IssuanceDate      string            `json:"issuanceDate"`
+	ExpirationDate    string            `json:"expirationDate,omitempty"`
+	CredentialSubject CredentialSubject `json:"credentialSubject"`
+	CredentialStatus  CredentialStatus  `json:"credentialStatus"`
+	Proof             *CredentialProof  `json:"proof,omitempty"`
+}
+
+// CredentialIssuer is the organization that issued the certificate
+type CredentialIssuer struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// CredentialSubject holds the certified facts about the partner
+type CredentialSubject struct {
+	ID              string `json:"id"`
+	PartnerName     string `json:"partnerName"`
+	CertificateName string `json:"certificateName"`
+	CertificateType string `json:"certificateType"`
+	Score           string `json:"score,omitempty"`
+	ValidFrom       string `json:"validFrom,omitempty"`
+	ValidUntil      string `json:"validUntil,omitempty"`
+}
+
+// CredentialStatus points back to the certificate record on the ledger
+type CredentialStatus struct {
+	ID              string `json:"id"`
+	Type            string `json:"type"`
+	Channel         string `json:"channel"`
+	CertificateHash string `json:"certificateHash"`
+	Status          string `json:"status"`
+	CheckedAt       string `json:"checkedAt"`
+}
+
+// CredentialProof carries the detached issuer signature over the canonical
+// certificate record. It attests the ledger record, not the credential, so a
+// Verifiable Credential library can not check it: rebuild the canonical
+// record, or call verifyIssuerSignature with the certificateHash.
+type CredentialProof struct {
+	Type               string `json:"type"`
+	Created            string `json:"created"`
+	ProofPurpose       string `json:"proofPurpose"`
+	VerificationMethod string `json:"verificationMethod"`
+	ProofValue         string `json:"proofValue"`
+}
+
+// ===============================================================================
+// exportCredential - render a certificate as a W3C Verifiable Credential
+// args: CertificateHash
+// Certificates without an issuer, created before issuers were recorded, and
+// certificates with dates that do not parse can not be exported.
+// ===============================================================================
+func (s *SmartContract) exportCredential(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	certificate, err := getCertificate(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if certificate == nil {
+		return shim.Error("Certificate does not exist: " + args[0])
+	}
+	if err = resolvePartner(stub, certificate, nil); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	credential, err := newCredential(stub.GetChannelID(), certificate, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	credentialAsBytes, err := json.Marshal(credential)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("- exportCredential %s\n", args[0])
+	return shim.Success(credentialAsBytes)
+}
+
+// newCredential renders certificate as a Verifiable Credential, with its
+// ledger status evaluated at now
+func newCredential(channel string, certificate *Certificate, now time.Time) (*VerifiableCredential, error) {
+	if certificate.Issuer == nil {
+		return nil, fmt.Errorf("Certificate %s has no issuer", certificate.CertificateHash)
+	}
+	validFrom, err := credentialDate(certificate.PassingDate)
+	if err != nil {
+		return nil, fmt.Errorf("Certificate %s PassingDate %s", certificate.CertificateHash, err)
+	}
+	validUntil, err := credentialDate(certificate.ExpiryDate)
+	if err != nil {
+		return nil, fmt.Errorf("Certificate %s ExpiryDate %s", certificate.CertificateHash, err)
+	}
+
+	id := fmt.Sprintf("urn:fabric:%s:certificate:%s", channel, certificate.CertificateHash)
+
+	credential := &VerifiableCredential{
+		Context: []interface{}{"https://www.w3.org/2018/credentials/v1", CredentialContext},
+		ID:      id,
+		Type:    []string{"VerifiableCredential", CredentialType},
+		CredentialSubject: CredentialSubject{
+			ID:              "urn:fabric:partner:" + certificate.PartnerID,
+			PartnerName:     certificate.PartnerName,
+			CertificateName: certificate.CertificateName,
+			CertificateType: certificate.CertificateType,
+			Score:           certificate.Score,
+			ValidFrom:       validFrom,
+			ValidUntil:      validUntil,
+		},
+		CredentialStatus: CredentialStatus{
+			ID:              id + "#status",
+			Type:            "FabricLedgerStatus",
+			Channel:         channel,
+			CertificateHash: certificate.CertificateHash,
+			Status:          certificateLedgerStatus(certificate, now),
+			CheckedAt:       now.Format(time.RFC3339),
+		},
+	}
+	if certificate.PartnerID == "" {
+		credential.CredentialSubject.ID = "urn:fabric:partner-name:" + certificate.PartnerName
+	}
+
+	credential.Issuer = CredentialIssuer{ID: "urn:fabric:msp:" + certificate.Issuer.MSPID, Name: certificate.Issuer.MSPID}
+	credential.IssuanceDate = certificate.Issuer.IssuedAt
+	if certificate.Issuer.Signature != "" {
+		credential.Proof = &CredentialProof{
+			Type:               "FabricIssuerRecordSignature",
+			Created:            certificate.Issuer.IssuedAt,
+			ProofPurpose:       "assertionMethod",
+			VerificationMethod: credential.Issuer.ID + "#" + certificate.Issuer.ID,
+			ProofValue:         certificate.Issuer.Signature,
+		}
+	}
+	credential.ExpirationDate = credential.CredentialSubject.ValidUntil
+
+	return credential, nil
+}
+
+// certificateLedgerStatus returns the status of certificate on the ledger at now:
+// revoked, superseded, expired or the label of its CertificateStatus
+func certificateLedgerStatus(certificate *Certificate, now time.Time) string {
+	if certificate.Revocation != nil {
+		return "revoked"
+	}
+	if certificate.SupersededBy != "" {
+		return "superseded"
+	}
+	if certificate.ExpiryDate != "" {
+		if expiry, err := parseCertificateDate(certificate.ExpiryDate); err == nil {
+			if len(certificate.ExpiryDate) == len("2006-01-02") {
+				// a plain date is valid until the end of that day
+				expiry = expiry.AddDate(0, 0, 1)
+			}
+			if !now.Before(expiry) {
+				return "expired"
+			}
+		}
+	}
+	if label, ok := CertificateStatusMap[certificate.CertificateStatus]; ok {
+		return label
+	}
+	return "unknown"
+}
+
+// credentialDate converts a certificate date to an XML Schema dateTime. An
+// empty date stays empty.
+func credentialDate(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	t, err := parseCertificateDate(value)
+	if err != nil {
+		return "", err
+	}
+	return t.UTC().Format(time.RFC3339), nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// CertificateEventSchemaVersion is the version of the event payload described
+// by schema/certificate-event.schema.json. Fields are only ever added within
+// a version.
+const CertificateEventSchemaVersion = 1
+
+// Certificate event types
+const (
+	CertificateCreated       = "CertificateCreated"
+	CertificateUpdated       = "CertificateUpdated"
+	CertificateStatusChanged = "CertificateStatusChanged"
+	CertificateRenewed       = "CertificateRenewed"
+	CertificateRevoked       = "CertificateRevoked"
+	CertificateRemoved       = "CertificateRemoved"
+)
+
+// CertificateEventsName is the chaincode event name of a transaction that
+// emits more than one event, e.g. renewCertificate or importCertificates.
+// A single event is emitted under its EventType.
+const CertificateEventsName = "CertificateEvents"
+
+// CertificateEvent describes a single change of a certificate record. It
+// carries no personal data.
+type CertificateEvent struct {
+	EventType       string `json:"EventType"`
+	CertificateHash string `json:"CertificateHash"`
+	OldStatus       string `json:"OldStatus"` // empty for a created certificate
+	NewStatus       string `json:"NewStatus"` // empty for a removed certificate
+	Timestamp       string `json:"Timestamp"` // RFC 3339 transaction timestamp
+	TxID            string `json:"TxID"`
+}
+
+// CertificateEventPayload is the payload of every certificate chaincode event.
+// Fabric keeps only the last event set by a transaction, so all events of a
+// transaction travel in one payload.
+type CertificateEventPayload struct {
+	SchemaVersion int                `json:"SchemaVersion"`
+	Events        []CertificateEvent `json:"Events"`
+}
+
+// certificateBatch collects the state shared by the certificate writes of a
+// single transaction
+type certificateBatch struct {
+	stats  scoreCache
+	events []CertificateEvent
+}
+
+func newCertificateBatch() *certificateBatch {
+	return &certificateBatch{stats: scoreCache{}}
+}
+
+// emit sets the chaincode event of the collected events, if any
+func (b *certificateBatch) emit(stub shim.ChaincodeStubInterface) error {
+	return setCertificateEvents(stub, b.events)
+}
+
+// newCertificateEvent classifies the change from previous to certificate.
+// previous is nil for a new record and certificate is nil for a removed one.
+func newCertificateEvent(stub shim.ChaincodeStubInterface, previous *Certificate, certificate *Certificate) (CertificateEvent, error) {
+	event := CertificateEvent{TxID: stub.GetTxID()}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return event, err
+	}
+	event.Timestamp = timestamp.Format(time.RFC3339)
+
+	switch {
+	case previous == nil:
+		event.EventType = CertificateCreated
+	case certificate == nil:
+		event.EventType = CertificateRemoved
+	case certificate.Revocation != nil && previous.Revocation == nil:
+		event.EventType = CertificateRevoked
+	case certificate.SupersededBy != previous.SupersededBy:
+		event.EventType = CertificateRenewed
+	case certificate.CertificateStatus != previous.CertificateStatus:
+		event.EventType = CertificateStatusChanged
+	default:
+		event.EventType = CertificateUpdated
+	}
+
+	if previous != nil {
+		event.CertificateHash = previous.CertificateHash
+		event.OldStatus = previous.CertificateStatus
+	}
+	if certificate != nil {
+		event.CertificateHash = certificate.CertificateHash
+		event.NewStatus = certificate.CertificateStatus
+	}
+	return event, nil
+}
+
+// setCertificateEvents sets the chaincode event of a transaction
+func setCertificateEvents(stub shim.ChaincodeStubInterface, events []CertificateEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	name := CertificateEventsName
+	if len(events) == 1 {
+		name = events[0].EventType
+	}
+	payload, err := json.Marshal(CertificateEventPayload{SchemaVersion: CertificateEventSchemaVersion, Events: events})
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(name, payload)
+}
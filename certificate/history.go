@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// historyIgnoredFields are bookkeeping fields left out of history diffs
+var historyIgnoredFields = map[string]bool{
+	"ChangedFields": true,
+	"LastTxID":      true,
+}
+
+// personalDataMasks masks the personal data fields of a record in history
+// diffs, at any depth. Participant is a plain string in schema versions
+// before 3 and a list of participants with a Name since.
+var personalDataMasks = map[string]func(string) string{
+	"Contacts":    maskName,
+	"Mobile":      maskMobile,
+	"Email":       maskEmail,
+	"Participant": maskName,
+	"Name":        maskName,
+}
+
+// FieldChange is the old and new value of a single field
+type FieldChange struct {
+	Field string      `json:"Field"`
+	Old   interface{} `json:"Old"`
+	New   interface{} `json:"New"`
+}
+
+// HistoryChange lists the fields changed by one transaction
+type HistoryChange struct {
+	TxId       string        `json:"TxId"`
+	Timestamp  string        `json:"Timestamp"`  // RFC 3339
+	ChangeType string        `json:"ChangeType"` // create, update or delete
+	Changes    []FieldChange `json:"Changes"`
+}
+
+// getHistoryDiff returns the field-level changes of a record, oldest first.
+// args: [from [, to [, limit]]] where from and to are RFC 3339 timestamps or
+// empty strings, and limit keeps only the most recent changes in the range.
+func (t *SmartContract) getHistoryDiff(stub shim.ChaincodeStubInterface, recordKey string, args []string) pb.Response {
+	if len(args) > 3 {
+		return shim.Error("Incorrect number of arguments. Expecting key, diff [, from [, to [, limit]]]")
+	}
+
+	var from, to time.Time
+	var err error
+	if len(args) > 0 && args[0] != "" {
+		if from, err = time.Parse(time.RFC3339, args[0]); err != nil {
+			return shim.Error("from must be an RFC 3339 timestamp")
+		}
+	}
+	if len(args) > 1 && args[1] != "" {
+		if to, err = time.Parse(time.RFC3339, args[1]); err != nil {
+			return shim.Error("to must be an RFC 3339 timestamp")
+		}
+	}
+	limit := 0
+	if len(args) > 2 && args[2] != "" {
+		if limit, err = strconv.Atoi(args[2]); err != nil || limit < 0 {
+			return shim.Error("limit must be a non-negative number")
+		}
+	}
+	fmt.Printf("- start getHistoryDiff: %s\n", recordKey)
+
+	resultsIterator, err := stub.GetHistoryForKey(recordKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	// versions are returned in commit order, which the diffs need. The
+	// client-set transaction timestamps are only used for the from and to
+	// filter.
+	type version struct {
+		txID      string
+		timestamp time.Time
+		value     map[string]interface{}
+	}
+	var versions []version
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		v := version{txID: response.TxId, timestamp: time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).UTC()}
+		if !response.IsDelete {
+			v.value = map[string]interface{}{}
+			decoder := json.NewDecoder(bytes.NewReader(response.Value))
+			decoder.UseNumber()
+			if err = decoder.Decode(&v.value); err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+		versions = append(versions, v)
+	}
+
+	masked := !canSeePersonalData(stub)
+	changes := []HistoryChange{}
+	var previous map[string]interface{}
+	for _, v := range versions {
+		change := HistoryChange{TxId: v.txID, Timestamp: v.timestamp.Format(time.RFC3339), Changes: []FieldChange{}}
+		switch {
+		case v.value == nil:
+			change.ChangeType = "delete"
+		case previous == nil:
+			change.ChangeType = "create"
+		default:
+			change.ChangeType = "update"
+		}
+		change.Changes = diffHistoryValues(previous, v.value, masked)
+		previous = v.value
+
+		if (!from.IsZero() && v.timestamp.Before(from)) || (!to.IsZero() && v.timestamp.After(to)) {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	if limit > 0 && len(changes) > limit {
+		changes = changes[len(changes)-limit:]
+	}
+
+	changesAsBytes, err := json.Marshal(changes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- getHistoryDiff returning %d changes\n", len(changes))
+	return shim.Success(changesAsBytes)
+}
+
+// diffHistoryValues returns the changed fields between two versions of a
+// record, sorted by field name. A nil version is a missing or deleted record.
+func diffHistoryValues(previous map[string]interface{}, current map[string]interface{}, masked bool) []FieldChange {
+	fields := map[string]bool{}
+	for name := range previous {
+		fields[name] = true
+	}
+	for name := range current {
+		fields[name] = true
+	}
+
+	names := []string{}
+	for name := range fields {
+		if !historyIgnoredFields[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := []FieldChange{}
+	for _, name := range names {
+		before, after := previous[name], current[name]
+		if reflect.DeepEqual(before, after) {
+			continue
+		}
+		if masked {
+			before, after = maskHistoryValue(name, before), maskHistoryValue(name, after)
+		}
+		changes = append(changes, FieldChange{Field: name, Old: before, New: after})
+	}
+	return changes
+}
+
+// maskHistoryValue masks the value of field name, and the personal data
+// fields of the objects and lists it holds
+func maskHistoryValue(name string, value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		if mask, ok := personalDataMasks[name]; ok {
+			return mask(value)
+		}
+	case map[string]interface{}:
+		masked := map[string]interface{}{}
+		for field, fieldValue := range value {
+			masked[field] = maskHistoryValue(field, fieldValue)
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(value))
+		for i, item := range value {
+			masked[i] = maskHistoryValue(name, item)
+		}
+		return masked
+	}
+	return value
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Issuer identifies the organization and client that issued a Certificate
+type Issuer struct {
+	MSPID       string `json:"MSPID"`
+	ID          string `json:"ID"`
+	Certificate string `json:"Certificate"` // PEM encoded X.509 certificate of the invoker
+	IssuedAt    string `json:"IssuedAt"`    // RFC 3339 transaction timestamp
+	Signature   string `json:"Signature,omitempty"`
+	// CanonicalVersion selects the canonical form the signature covers. It
+	// is missing on records issued before version 2.
+	CanonicalVersion int `json:"CanonicalVersion,omitempty"`
+	// SignedPartner keeps the partner fields covered by a version 1
+	// signature once the record is linked to the partner registry
+	SignedPartner *Partner `json:"SignedPartner,omitempty"`
+}
+
+// CanonicalVersion is the canonical form signed by new issuers
+const CanonicalVersion = 2
+
+// signedCertificateFields are the Certificate fields covered by the issuer
+// signature, by canonical version. The canonical record is a JSON object
+// holding exactly these fields, with keys sorted and no insignificant
+// whitespace. Version 1 covers the partner fields copied into the record,
+// version 2 covers the partner through its PartnerID, so register the
+// partner before signing. An empty Participant list, or one holding a single
+// participant with only a Name, is signed as a plain string as before schema
+// version 3, so older signatures stay valid.
+var signedCertificateFieldsV1 = []string{
+	"CertificateHash",
+	"PartnerName",
+	"Contacts",
+	"Mobile",
+	"Email",
+	"CertificateType",
+	"CertificateName",
+	"PassingDate",
+	"ExpiryDate",
+	"CertificateStatus",
+	"Participant",
+	"Score",
+}
+
+var signedCertificateFields = []string{
+	"CertificateHash",
+	"PartnerID",
+	"CertificateType",
+	"CertificateName",
+	"PassingDate",
+	"ExpiryDate",
+	"CertificateStatus",
+	"Participant",
+	"Score",
+}
+
+// ecdsaSignature is the ASN.1 structure of an ECDSA signature
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// ===============================================================================
+// verifyIssuerSignature - check the issuer signature of a certificate record
+// args: CertificateHash [, signature]
+// Without a signature argument the signature stored at creation is checked.
+// ===============================================================================
+func (s *SmartContract) verifyIssuerSignature(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
+	}
+
+	certificateAsBytes, err := stub.GetState(args[0])
+	if err != nil {
+		return shim.Error("Failed to get Certificate record: " + err.Error())
+	} else if certificateAsBytes == nil {
+		return shim.Error("Certificate does not exist: " + args[0])
+	}
+
+	certificate := Certificate{}
+	if err = json.Unmarshal(certificateAsBytes, &certificate); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	result := struct {
+		CertificateHash string `json:"CertificateHash"`
+		IssuerMSPID     string `json:"IssuerMSPID"`
+		IssuerID        string `json:"IssuerID"`
+		Signed          bool   `json:"Signed"`
+		Valid           bool   `json:"Valid"`
+		Reason          string `json:"Reason,omitempty"`
+	}{CertificateHash: certificate.CertificateHash}
+
+	signature := ""
+	if len(args) == 2 {
+		signature = args[1]
+	}
+
+	if certificate.Issuer == nil {
+		result.Reason = "certificate has no issuer"
+	} else {
+		result.IssuerMSPID = certificate.Issuer.MSPID
+		result.IssuerID = certificate.Issuer.ID
+		if signature == "" {
+			signature = certificate.Issuer.Signature
+		}
+
+		if signature == "" {
+			result.Reason = "certificate is not signed"
+		} else {
+			result.Signed = true
+			if certificate.Issuer.canonicalVersion() == 1 && certificate.Issuer.SignedPartner == nil {
+				// linked before the partner fields were kept, use the registry copy
+				if err = resolvePartner(stub, &certificate, nil); err != nil {
+					return shim.Error(err.Error())
+				}
+			}
+			if err = verifySignature(certificate.Issuer, &certificate, signature); err != nil {
+				result.Reason = err.Error()
+			} else {
+				result.Valid = true
+			}
+		}
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
+
+// newIssuer builds the issuer block from the identity of the invoker
+func newIssuer(stub shim.ChaincodeStubInterface) (*Issuer, error) {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get invoker MSP ID: %s", err)
+	}
+	id, err := cid.GetID(stub)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get invoker ID: %s", err)
+	}
+	certificate, err := cid.GetX509Certificate(stub)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get invoker certificate: %s", err)
+	}
+	issuedAt, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := &Issuer{MSPID: mspID, ID: id, IssuedAt: issuedAt.Format(time.RFC3339), CanonicalVersion: CanonicalVersion}
+	if certificate != nil {
+		issuer.Certificate = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw}))
+	}
+	return issuer, nil
+}
+
+// reissueCertificate records the invoker as the issuer of a certificate whose
+// signed fields changed, since the previous issuer and signature no longer
+// cover the record. The previous issuer stays in the history of the record.
+func reissueCertificate(stub shim.ChaincodeStubInterface, previous *Certificate, certificate *Certificate) error {
+	if previous.Issuer == nil {
+		return nil
+	}
+	before, err := canonicalCertificateBytes(previous)
+	if err != nil {
+		return err
+	}
+	after, err := canonicalCertificateBytes(certificate)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(before, after) {
+		return nil
+	}
+
+	certificate.Issuer, err = newIssuer(stub)
+	return err
+}
+
+// canonicalVersion returns the canonical form covered by the issuer signature
+func (issuer *Issuer) canonicalVersion() int {
+	if issuer == nil || issuer.CanonicalVersion == 0 {
+		return 1
+	}
+	return issuer.CanonicalVersion
+}
+
+// canonicalCertificateBytes returns the canonical record signed by the issuer
+// of the certificate, in the canonical version the issuer signed
+func canonicalCertificateBytes(certificate *Certificate) ([]byte, error) {
+	fields := signedCertificateFields
+	if certificate.Issuer.canonicalVersion() == 1 {
+		fields = signedCertificateFieldsV1
+		if partner := certificate.Issuer.SignedPartner; partner != nil {
+			copied := *certificate
+			copied.PartnerName = partner.PartnerName
+			copied.Contacts = partner.Contacts
+			copied.Mobile = partner.Mobile
+			copied.Email = partner.Email
+			certificate = &copied
+		}
+	}
+
+	document, err := certificateToMap(certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	canonical := map[string]interface{}{}
+	for _, name := range fields {
+		canonical[name] = document[name]
+	}
+	if name, ok := certificate.Participant.legacyName(); ok {
+		canonical["Participant"] = name
+	}
+	// encoding/json writes map keys in sorted order
+	return json.Marshal(canonical)
+}
+
+// verifySignature checks a base64 encoded, ASN.1 DER ECDSA signature over the
+// SHA-256 digest of the canonical record against the issuer's public key
+func verifySignature(issuer *Issuer, certificate *Certificate, signature string) error {
+	block, _ := pem.Decode([]byte(issuer.Certificate))
+	if block == nil {
+		return fmt.Errorf("issuer certificate is missing")
+	}
+	issuerCertificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("issuer certificate is invalid: %s", err)
+	}
+	publicKey, ok := issuerCertificate.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("issuer public key is not an ECDSA key")
+	}
+
+	signatureAsBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("signature is not valid base64")
+	}
+	sig := ecdsaSignature{}
+	if rest, err := asn1.Unmarshal(signatureAsBytes, &sig); err != nil || len(rest) != 0 {
+		return fmt.Errorf("signature is not an ASN.1 ECDSA signature")
+	}
+	if sig.R == nil || sig.S == nil || sig.R.Sign() <= 0 || sig.S.Sign() <= 0 {
+		return fmt.Errorf("signature is not an ASN.1 ECDSA signature")
+	}
+
+	signed := *certificate
+	signed.Issuer = issuer
+	canonical, err := canonicalCertificateBytes(&signed)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(canonical)
+	if !ecdsa.Verify(publicKey, digest[:], sig.R, sig.S) {
+		return fmt.Errorf("signature does not match the record")
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// CertificateSortIndexMap maps the sort options of queryAllCertificate to
+// the indexes holding the certificates in that order
+var CertificateSortIndexMap = map[string]string{
+	"PassingDate": "passingdate~hash",
+	"ExpiryDate":  "expirydate~hash",
+}
+
+// MaxPageSize limits the page size of queryAllCertificate
+const MaxPageSize = 1000
+
+// CertificateQueryResult is a single certificate of a query response
+type CertificateQueryResult struct {
+	Key    string          `json:"Key"`
+	Record json.RawMessage `json:"Record"`
+}
+
+// CertificatePage is a page of the paginated queryAllCertificate. Pass
+// Bookmark to the next call to get the following page. A page of a
+// key-ordered query may hold fewer than the requested records, since other
+// keys in the range are skipped.
+type CertificatePage struct {
+	Records  []CertificateQueryResult `json:"Records"`
+	Count    int                      `json:"Count"`
+	Bookmark string                   `json:"Bookmark"`
+	SortBy   string                   `json:"SortBy"` // CertificateHash, PassingDate or ExpiryDate
+}
+
+// ===============================================================================
+// queryCertificatePage - a page of certificate records
+// args: page size, bookmark [, sort by PassingDate or ExpiryDate]
+// Without a sort option certificates are ordered by CertificateHash. Dates
+// sort as YYYY-MM-DD, certificates without a date come first.
+// ===============================================================================
+func (s *SmartContract) queryCertificatePage(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting page size, bookmark [, sort by]")
+	}
+	pageSize, err := strconv.Atoi(args[0])
+	if err != nil || pageSize <= 0 || pageSize > MaxPageSize {
+		return shim.Error(fmt.Sprintf("1st argument page size must be a number from 1 to %d", MaxPageSize))
+	}
+	bookmark := args[1]
+	sortBy := "CertificateHash"
+	if len(args) == 3 && args[2] != "" {
+		sortBy = args[2]
+	}
+
+	var resultsIterator shim.StateQueryIteratorInterface
+	var metadata *pb.QueryResponseMetadata
+	if indexName, ok := CertificateSortIndexMap[sortBy]; ok {
+		resultsIterator, metadata, err = stub.GetStateByPartialCompositeKeyWithPagination(indexName, []string{}, int32(pageSize), bookmark)
+	} else if sortBy == "CertificateHash" {
+		resultsIterator, metadata, err = stub.GetStateByRangeWithPagination("", "", int32(pageSize), bookmark)
+	} else {
+		return shim.Error("Incorrect sort option [PassingDate, ExpiryDate]")
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	masked := !canSeePersonalData(stub)
+	page := CertificatePage{Records: []CertificateQueryResult{}, Bookmark: metadata.Bookmark, SortBy: sortBy}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		key := queryResponse.Key
+		value := queryResponse.Value
+		if sortBy != "CertificateHash" {
+			// an index entry, the hash is its last attribute
+			_, compositeKeyParts, err := stub.SplitCompositeKey(key)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			key = compositeKeyParts[len(compositeKeyParts)-1]
+			if value, err = stub.GetState(key); err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+
+		certificate := Certificate{}
+		if value == nil || json.Unmarshal(value, &certificate) != nil || certificate.CertificateHash != key {
+			// not a certificate record, or a stale index entry
+			continue
+		}
+		page.Records = append(page.Records, CertificateQueryResult{Key: key, Record: certificateRecordBytes(stub, value, masked, true)})
+	}
+	page.Count = len(page.Records)
+
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- queryCertificatePage returning %d certificates\n", page.Count)
+	return shim.Success(pageAsBytes)
+}
+
+// sortableDate returns a PassingDate or ExpiryDate as YYYY-MM-DD, so the date
+// indexes sort chronologically. Values that are not dates are kept as-is.
+func sortableDate(value string) string {
+	date, err := parseCertificateDate(value)
+	if err != nil {
+		return value
+	}
+	return date.Format("2006-01-02")
+}
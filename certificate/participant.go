@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ParticipantIndexName indexes certificates by the employee ID of their participants
+const ParticipantIndexName = "participant~cert"
+
+// Participant is a person covered by a certificate
+type Participant struct {
+	Name       string `json:"Name"`
+	EmployeeID string `json:"EmployeeID"`
+	Score      string `json:"Score"` // individual score, a number or empty
+}
+
+// Participants is the Participant list of a certificate. Records before
+// schema version 3 hold a single participant name as a plain string, which
+// is read as a list holding that participant.
+type Participants []Participant
+
+// UnmarshalJSON accepts a list of participants or a legacy participant name
+func (p *Participants) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*p = nil
+		if name != "" {
+			*p = Participants{{Name: name}}
+		}
+		return nil
+	}
+
+	var participants []Participant
+	if err := json.Unmarshal(data, &participants); err != nil {
+		return fmt.Errorf("Participant must be a list of participants or a name")
+	}
+	*p = participants
+	return nil
+}
+
+// MarshalJSON writes an empty list rather than null
+func (p Participants) MarshalJSON() ([]byte, error) {
+	if p == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]Participant(p))
+}
+
+// legacyName returns the plain name a schema version 2 record would hold for
+// this list. It is false for lists that need the schema version 3 layout.
+func (p Participants) legacyName() (string, bool) {
+	if len(p) == 0 {
+		return "", true
+	}
+	if len(p) == 1 && p[0].EmployeeID == "" && p[0].Score == "" {
+		return p[0].Name, true
+	}
+	return "", false
+}
+
+// ===============================================================================
+// queryCertificatesByParticipant - list the certificates covering an employee
+// args: EmployeeID
+// ===============================================================================
+func (s *SmartContract) queryCertificatesByParticipant(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	fmt.Println("- start queryCertificatesByParticipant", args[0])
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(ParticipantIndexName, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	masked := !canSeePersonalData(stub)
+
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+	bArrayMemberAlreadyWritten := false
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		certificateHash := compositeKeyParts[1]
+		certificateAsBytes, err := stub.GetState(certificateHash)
+		if err != nil {
+			return shim.Error(err.Error())
+		} else if certificateAsBytes == nil {
+			// stale index entry
+			continue
+		}
+
+		if bArrayMemberAlreadyWritten == true {
+			buffer.WriteString(",")
+		}
+		buffer.WriteString("{\"Key\":")
+		buffer.WriteString("\"")
+		buffer.WriteString(certificateHash)
+		buffer.WriteString("\"")
+
+		buffer.WriteString(", \"Record\":")
+		buffer.Write(certificateRecordBytes(stub, certificateAsBytes, masked, true))
+		buffer.WriteString("}")
+		bArrayMemberAlreadyWritten = true
+	}
+	buffer.WriteString("]")
+
+	fmt.Printf("- queryCertificatesByParticipant returning:\n%s\n", buffer.String())
+	return shim.Success(buffer.Bytes())
+}
+
+// parseParticipants reads the Participant argument of the positional
+// createCertificate and updateCertificate calls and the Participant column of
+// a CSV import: a JSON list of participants, or a single participant name
+func parseParticipants(value string) (Participants, error) {
+	if !strings.HasPrefix(strings.TrimSpace(value), "[") {
+		if value == "" {
+			return nil, nil
+		}
+		return Participants{{Name: value}}, nil
+	}
+
+	var participants Participants
+	if err := json.Unmarshal([]byte(value), &participants); err != nil {
+		return nil, fmt.Errorf("Invalid value for Participant: %s", err)
+	}
+	return participants, nil
+}
+
+// validateParticipants checks every participant of a certificate. Employee
+// IDs are optional, since records before schema version 3 have none, but
+// must be unique within the certificate.
+func validateParticipants(participants Participants) error {
+	employeeIDs := map[string]bool{}
+	for i, participant := range participants {
+		if err := validateRequired(participant.Name); err != nil {
+			return fmt.Errorf("Invalid Name of participant %d: %s", i+1, err)
+		}
+		if err := validateScore(participant.Score); err != nil {
+			return fmt.Errorf("Invalid Score of participant %d: %s", i+1, err)
+		}
+		if participant.EmployeeID == "" {
+			continue
+		}
+		if employeeIDs[participant.EmployeeID] {
+			return fmt.Errorf("Duplicate participant EmployeeID: %s", participant.EmployeeID)
+		}
+		employeeIDs[participant.EmployeeID] = true
+	}
+	return nil
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// PartnerObjectType is the composite key object type of partner records.
+// Partners live under composite keys so they never show up in range scans
+// of the certificate records.
+const PartnerObjectType = "partner"
+
+// PartnerNameIndexName indexes partners by their unique PartnerName
+const PartnerNameIndexName = "partnername~partner"
+
+// PartnerIDIndexName indexes certificates by the ID of their partner
+const PartnerIDIndexName = "partnerid~all"
+
+// Partner is a registered partner organization and its contact data. The
+// contact data shows on every certificate of the partner, so only admins
+// and the owning organization may change it.
+type Partner struct {
+	PartnerID   string `json:"PartnerID"`
+	PartnerName string `json:"PartnerName"`
+	Contacts    string `json:"Contacts"`
+	Mobile      string `json:"Mobile"`
+	Email       string `json:"Email"`
+	OwnerMSPID  string `json:"OwnerMSPID,omitempty"` // MSP ID of the owning organization, empty for partners left to admins
+}
+
+// partnerCache remembers partners read or written in the current transaction,
+// since GetState does not see the writes of the transaction itself
+type partnerCache map[string]*Partner
+
+func (c partnerCache) put(partner *Partner) {
+	c["id:"+partner.PartnerID] = partner
+	c["name:"+partner.PartnerName] = partner
+}
+
+// ===============================================================================
+// createPartner - register a partner, limited to admins
+// args: Partner JSON. PartnerID is derived from the PartnerName if it is empty,
+// and OwnerMSPID defaults to the MSP of the admin.
+// ===============================================================================
+func (s *SmartContract) createPartner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if !isAdmin(stub) {
+		return shim.Error("Only an admin can register a partner")
+	}
+
+	partner := &Partner{}
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(partner); err != nil {
+		return shim.Error("Invalid Partner JSON: " + err.Error())
+	}
+	if partner.PartnerID == "" {
+		partner.PartnerID = derivePartnerID(partner.PartnerName)
+	}
+	if err := validatePartner(partner); err != nil {
+		return shim.Error(err.Error())
+	}
+	if partner.OwnerMSPID == "" {
+		mspID, err := cid.GetMSPID(stub)
+		if err != nil {
+			return shim.Error("Failed to get invoker MSP ID: " + err.Error())
+		}
+		partner.OwnerMSPID = mspID
+	}
+
+	existing, err := getPartner(stub, partner.PartnerID, nil)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if existing != nil {
+		return shim.Error("Partner already exists: " + partner.PartnerID)
+	}
+	if existing, err = getPartnerByName(stub, partner.PartnerName, nil); err != nil {
+		return shim.Error(err.Error())
+	} else if existing != nil {
+		return shim.Error(fmt.Sprintf("Partner name %s is already registered as %s", partner.PartnerName, existing.PartnerID))
+	}
+
+	if err = storePartner(stub, nil, partner); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("- createPartner %s\n", partner.PartnerID)
+	return shim.Success([]byte(partner.PartnerID))
+}
+
+// ===============================================================================
+// updatePartner - apply a JSON merge-patch to a partner. Limited to admins and
+// the owning organization, only admins can change the owner.
+// args: PartnerID, patch
+// ===============================================================================
+func (s *SmartContract) updatePartner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	previous, err := getPartner(stub, args[0], nil)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if previous == nil {
+		return shim.Error("Partner does not exist: " + args[0])
+	}
+	admin := isAdmin(stub)
+	if !admin {
+		mspID, err := cid.GetMSPID(stub)
+		if err != nil {
+			return shim.Error("Failed to get invoker MSP ID: " + err.Error())
+		}
+		if previous.OwnerMSPID == "" || previous.OwnerMSPID != mspID {
+			return shim.Error("Only the owning organization or an admin can update partner " + args[0])
+		}
+	}
+
+	patch := map[string]interface{}{}
+	if err = json.Unmarshal([]byte(args[1]), &patch); err != nil {
+		return shim.Error("Patch must be a JSON object: " + err.Error())
+	}
+	if _, ok := patch["PartnerID"]; ok {
+		return shim.Error("Partner field can not be patched: PartnerID")
+	}
+	if _, ok := patch["OwnerMSPID"]; ok && !admin {
+		return shim.Error("Only an admin can change the owner of partner " + args[0])
+	}
+
+	partnerAsBytes, err := json.Marshal(previous)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	document := map[string]interface{}{}
+	if err = json.Unmarshal(partnerAsBytes, &document); err != nil {
+		return shim.Error(err.Error())
+	}
+	if partnerAsBytes, err = json.Marshal(mergePatch(document, patch)); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	partner := &Partner{}
+	decoder := json.NewDecoder(strings.NewReader(string(partnerAsBytes)))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(partner); err != nil {
+		return shim.Error("Invalid Partner patch: " + err.Error())
+	}
+	if err = validatePartner(partner); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if partner.PartnerName != previous.PartnerName {
+		existing, err := getPartnerByName(stub, partner.PartnerName, nil)
+		if err != nil {
+			return shim.Error(err.Error())
+		} else if existing != nil {
+			return shim.Error(fmt.Sprintf("Partner name %s is already registered as %s", partner.PartnerName, existing.PartnerID))
+		}
+	}
+
+	if err = storePartner(stub, previous, partner); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("- updatePartner %s\n", partner.PartnerID)
+	return shim.Success(nil)
+}
+
+// ===============================================================================
+// queryPartner - read a partner, masking its contact data for non-admins
+// args: PartnerID
+// ===============================================================================
+func (s *SmartContract) queryPartner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	partner, err := getPartner(stub, args[0], nil)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if partner == nil {
+		return shim.Error("Partner does not exist: " + args[0])
+	}
+
+	if !canSeePersonalData(stub) {
+		partner.Contacts = maskName(partner.Contacts)
+		partner.Mobile = maskMobile(partner.Mobile)
+		partner.Email = maskEmail(partner.Email)
+	}
+
+	partnerAsBytes, err := json.Marshal(partner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(partnerAsBytes)
+}
+
+// ===============================================================================
+// migratePartners - move the partner data copied into certificates to the
+// partner registry. Admin only.
+// args: batch size [, start key]
+// Returns the number of migrated certificates and the key to resume from,
+// which is empty once every certificate has been visited.
+// ===============================================================================
+func (s *SmartContract) migratePartners(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
+	}
+	if !isAdmin(stub) {
+		return shim.Error("Only an admin can migrate partners")
+	}
+
+	batchSize, err := strconv.Atoi(args[0])
+	if err != nil || batchSize <= 0 {
+		return shim.Error("1st argument batch size must be a positive number")
+	}
+	startKey := ""
+	if len(args) == 2 {
+		startKey = args[1]
+	}
+
+	resultsIterator, err := stub.GetStateByRange(startKey, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	cache := partnerCache{}
+	batch := newCertificateBatch()
+	result := struct {
+		Migrated int    `json:"Migrated"`
+		Next     string `json:"Next"`
+	}{}
+
+	for visited := 0; resultsIterator.HasNext(); visited++ {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if visited == batchSize {
+			result.Next = queryResponse.Key
+			break
+		}
+
+		previous := Certificate{}
+		if err = json.Unmarshal(queryResponse.Value, &previous); err != nil || previous.CertificateHash != queryResponse.Key {
+			// not a certificate record
+			continue
+		}
+		if previous.PartnerID != "" || previous.PartnerName == "" {
+			continue
+		}
+
+		certificate := previous
+		if err = linkPartner(stub, &certificate, cache); err != nil {
+			return shim.Error(fmt.Sprintf("Failed to migrate %s: %s", queryResponse.Key, err))
+		}
+		if certificate.ChangedFields, err = diffCertificateFields(&previous, &certificate); err != nil {
+			return shim.Error(err.Error())
+		}
+		if err = storeCertificate(stub, &previous, &certificate, batch); err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Migrated++
+	}
+
+	if err = batch.emit(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- migratePartners: %s\n", resultAsBytes)
+	return shim.Success(resultAsBytes)
+}
+
+// linkPartner points certificate at its registered partner and removes the
+// copied partner fields. Certificates without a PartnerID are matched by
+// PartnerName, registering the partner from the certificate fields if needed.
+func linkPartner(stub shim.ChaincodeStubInterface, certificate *Certificate, cache partnerCache) error {
+	var partner *Partner
+	var err error
+
+	if certificate.PartnerID != "" {
+		if partner, err = getPartner(stub, certificate.PartnerID, cache); err != nil {
+			return err
+		} else if partner == nil {
+			return fmt.Errorf("Partner does not exist: %s", certificate.PartnerID)
+		}
+	} else {
+		if certificate.PartnerName == "" {
+			return fmt.Errorf("Certificate needs a PartnerID or a PartnerName")
+		}
+		if partner, err = getPartnerByName(stub, certificate.PartnerName, cache); err != nil {
+			return err
+		}
+		if partner == nil {
+			// a partner registered by its first certificate is owned by its issuer
+			var mspID string
+			if certificate.Issuer != nil {
+				mspID = certificate.Issuer.MSPID
+			} else if mspID, err = cid.GetMSPID(stub); err != nil {
+				return fmt.Errorf("Failed to get invoker MSP ID: %s", err)
+			}
+			partner = &Partner{
+				PartnerID:   derivePartnerID(certificate.PartnerName),
+				PartnerName: certificate.PartnerName,
+				Contacts:    certificate.Contacts,
+				Mobile:      certificate.Mobile,
+				Email:       certificate.Email,
+				OwnerMSPID:  mspID,
+			}
+			if err = storePartner(stub, nil, partner); err != nil {
+				return err
+			}
+			if cache != nil {
+				cache.put(partner)
+			}
+		}
+	}
+
+	// a version 1 signature covers the copied partner fields, keep them with it
+	if issuer := certificate.Issuer; issuer != nil && issuer.Signature != "" && issuer.canonicalVersion() == 1 &&
+		issuer.SignedPartner == nil && certificate.PartnerName != "" {
+		signed := *issuer
+		signed.SignedPartner = &Partner{
+			PartnerID:   partner.PartnerID,
+			PartnerName: certificate.PartnerName,
+			Contacts:    certificate.Contacts,
+			Mobile:      certificate.Mobile,
+			Email:       certificate.Email,
+		}
+		certificate.Issuer = &signed
+	}
+
+	certificate.PartnerID = partner.PartnerID
+	certificate.PartnerName = ""
+	certificate.Contacts = ""
+	certificate.Mobile = ""
+	certificate.Email = ""
+	return nil
+}
+
+// checkPartnerContacts rejects partner contacts that differ from the registered
+// partner, since linkPartner keeps only the registry copy. Empty contacts are
+// taken from the registry. Use updatePartner to change them.
+func checkPartnerContacts(stub shim.ChaincodeStubInterface, certificate *Certificate, cache partnerCache) error {
+	var partner *Partner
+	var err error
+	if certificate.PartnerID != "" {
+		partner, err = getPartner(stub, certificate.PartnerID, cache)
+	} else if certificate.PartnerName != "" {
+		partner, err = getPartnerByName(stub, certificate.PartnerName, cache)
+	}
+	if err != nil {
+		return err
+	} else if partner == nil {
+		return nil
+	}
+
+	if (certificate.Contacts != "" && certificate.Contacts != partner.Contacts) ||
+		(certificate.Mobile != "" && certificate.Mobile != partner.Mobile) ||
+		(certificate.Email != "" && certificate.Email != partner.Email) {
+		return fmt.Errorf("Partner %s is registered with other contacts, use updatePartner to change them", partner.PartnerID)
+	}
+	return nil
+}
+
+// resolvePartner fills the partner fields of a stored certificate from the registry
+func resolvePartner(stub shim.ChaincodeStubInterface, certificate *Certificate, cache partnerCache) error {
+	if certificate.PartnerID == "" {
+		// not migrated yet, the certificate still carries its own copy
+		return nil
+	}
+
+	partner, err := getPartner(stub, certificate.PartnerID, cache)
+	if err != nil {
+		return err
+	} else if partner == nil {
+		return nil
+	}
+
+	certificate.PartnerName = partner.PartnerName
+	certificate.Contacts = partner.Contacts
+	certificate.Mobile = partner.Mobile
+	certificate.Email = partner.Email
+	return nil
+}
+
+// getPartner reads a partner, returning nil if it does not exist
+func getPartner(stub shim.ChaincodeStubInterface, partnerID string, cache partnerCache) (*Partner, error) {
+	if partner, ok := cache["id:"+partnerID]; ok {
+		copied := *partner
+		return &copied, nil
+	}
+
+	key, err := stub.CreateCompositeKey(PartnerObjectType, []string{partnerID})
+	if err != nil {
+		return nil, err
+	}
+	partnerAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get Partner record: %s", err)
+	} else if partnerAsBytes == nil {
+		return nil, nil
+	}
+
+	partner := &Partner{}
+	if err = json.Unmarshal(partnerAsBytes, partner); err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		cache.put(partner)
+	}
+	copied := *partner
+	return &copied, nil
+}
+
+// getPartnerByName looks a partner up through the PartnerName index
+func getPartnerByName(stub shim.ChaincodeStubInterface, name string, cache partnerCache) (*Partner, error) {
+	if partner, ok := cache["name:"+name]; ok {
+		copied := *partner
+		return &copied, nil
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(PartnerNameIndexName, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	if !resultsIterator.HasNext() {
+		return nil, nil
+	}
+	responseRange, err := resultsIterator.Next()
+	if err != nil {
+		return nil, err
+	}
+	_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+	if err != nil {
+		return nil, err
+	}
+	return getPartner(stub, compositeKeyParts[1], cache)
+}
+
+// storePartner writes partner and moves its name index entry from previous,
+// which is nil for a new partner
+func storePartner(stub shim.ChaincodeStubInterface, previous *Partner, partner *Partner) error {
+	if previous != nil {
+		if err := deleteIndex(stub, PartnerNameIndexName, []string{previous.PartnerName, previous.PartnerID}); err != nil {
+			return err
+		}
+	}
+
+	key, err := stub.CreateCompositeKey(PartnerObjectType, []string{partner.PartnerID})
+	if err != nil {
+		return err
+	}
+	partnerAsBytes, err := json.Marshal(partner)
+	if err != nil {
+		return err
+	}
+	if err = stub.PutState(key, partnerAsBytes); err != nil {
+		return fmt.Errorf("Failed to record partner: %s", partner.PartnerID)
+	}
+
+	return createIndex(stub, PartnerNameIndexName, []string{partner.PartnerName, partner.PartnerID})
+}
+
+// validatePartner validates every field of partner
+func validatePartner(partner *Partner) error {
+	if err := validateRequired(partner.PartnerID); err != nil {
+		return fmt.Errorf("Invalid value for PartnerID: %s", err)
+	}
+	if err := validateRequired(partner.PartnerName); err != nil {
+		return fmt.Errorf("Invalid value for PartnerName: %s", err)
+	}
+	if err := validateMobile(partner.Mobile); err != nil {
+		return fmt.Errorf("Invalid value for Mobile: %s", err)
+	}
+	if err := validateEmail(partner.Email); err != nil {
+		return fmt.Errorf("Invalid value for Email: %s", err)
+	}
+	return nil
+}
+
+// derivePartnerID returns the PartnerID used for partners registered by name only
+func derivePartnerID(name string) string {
+	digest := sha256.Sum256([]byte(name))
+	return "P" + hex.EncodeToString(digest[:8])
+}
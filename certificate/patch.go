@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// certificateDateLayouts are the accepted formats of PassingDate and ExpiryDate
+var certificateDateLayouts = []string{"2006-01-02", "2006/01/02", time.RFC3339}
+
+// CertificateStatusMap lists the valid CertificateStatus codes
+var CertificateStatusMap = map[string]string{
+	"0": "passed",
+	"1": "failed",
+	"2": "downgraded",
+	"3": "cancelled",
+}
+
+// certificateFieldValidators checks a single field of a Certificate. Fields
+// without a validator accept any string.
+var certificateFieldValidators = map[string]func(string) error{
+	"CertificateHash":   validateRequired,
+	"CertificateName":   validateRequired,
+	"CertificateType":   validateRequired,
+	"Mobile":            validateMobile,
+	"Email":             validateEmail,
+	"PassingDate":       validateDate,
+	"ExpiryDate":        validateDate,
+	"CertificateStatus": validateCertificateStatus,
+	"Score":             validateScore,
+}
+
+// certificatePartnerFields are kept in the partner registry and changed with updatePartner
+var certificatePartnerFields = map[string]bool{
+	"PartnerName": true,
+	"Contacts":    true,
+	"Mobile":      true,
+	"Email":       true,
+}
+
+// certificateReadOnlyFields can not be changed by patchCertificate
+var certificateReadOnlyFields = map[string]bool{
+	"SchemaVersion":   true,
+	"CertificateHash": true,
+	"ChangedFields":   true,
+	"Issuer":          true,
+	"Supersedes":      true,
+	"SupersededBy":    true,
+	"Revocation":      true,
+	"LastTxID":        true,
+	// the link is checked against the SocialSecurity chaincode on creation
+	"SocialSecurityID":   true,
+	"SocialSecurityLink": true,
+}
+
+// ===============================================================================
+// patchCertificate - apply a JSON merge-patch (RFC 7396) to a certificate record
+// args: CertificateHash, patch
+// ===============================================================================
+func (s *SmartContract) patchCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	certificateAsBytes, err := stub.GetState(args[0])
+	if err != nil {
+		return shim.Error("Failed to get Certificate record: " + err.Error())
+	} else if certificateAsBytes == nil {
+		return shim.Error("Certificate does not exist: " + args[0])
+	}
+
+	previous := Certificate{}
+	if err = json.Unmarshal(certificateAsBytes, &previous); err != nil {
+		return shim.Error(err.Error())
+	}
+	if previous.Revocation != nil {
+		return shim.Error("Certificate is revoked: " + args[0])
+	}
+
+	patch := map[string]interface{}{}
+	if err = json.Unmarshal([]byte(args[1]), &patch); err != nil {
+		return shim.Error("Patch must be a JSON object: " + err.Error())
+	}
+
+	certificate, err := applyCertificatePatch(&previous, patch)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = linkPartner(stub, certificate, nil); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	changedFields, err := diffCertificateFields(&previous, certificate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(changedFields) == 0 {
+		fmt.Printf("- patchCertificate: nothing changed for %s\n", args[0])
+		return shim.Success(nil)
+	}
+	certificate.ChangedFields = changedFields
+	if err = reissueCertificate(stub, &previous, certificate); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err = storeCertificate(stub, &previous, certificate, nil); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("- patchCertificate %s changed %v\n", args[0], changedFields)
+	return shim.Success(nil)
+}
+
+// certificateFromJSON decodes and validates a single JSON Certificate object
+func certificateFromJSON(payload string) (*Certificate, error) {
+	decoder := json.NewDecoder(strings.NewReader(payload))
+	decoder.DisallowUnknownFields()
+
+	certificate := &Certificate{}
+	if err := decoder.Decode(certificate); err != nil {
+		return nil, fmt.Errorf("Invalid Certificate JSON: %s", err)
+	}
+	certificate.SchemaVersion = 0
+	certificate.ChangedFields = nil
+	// the issuer is taken from the invoker, the lineage is maintained by
+	// renewCertificate and the revocation by revokeCertificate, never from
+	// the payload
+	certificate.Issuer = nil
+	certificate.Supersedes = ""
+	certificate.SupersededBy = ""
+	certificate.Revocation = nil
+	certificate.LastTxID = ""
+	// the link is recorded by issueCertificate
+	certificate.SocialSecurityLink = nil
+
+	if err := validateCertificate(certificate); err != nil {
+		return nil, err
+	}
+	return certificate, nil
+}
+
+// applyCertificatePatch returns a copy of certificate with patch merged in.
+// Every patched field is validated on its own before it is applied.
+func applyCertificatePatch(certificate *Certificate, patch map[string]interface{}) (*Certificate, error) {
+	document, err := certificateToMap(certificate)
+	if err != nil {
+		return nil, err
+	}
+	fields := certificateFieldNames()
+
+	for name, value := range patch {
+		if !fields[name] {
+			return nil, fmt.Errorf("Unknown Certificate field in patch: %s", name)
+		}
+		if certificateReadOnlyFields[name] {
+			return nil, fmt.Errorf("Certificate field can not be patched: %s", name)
+		}
+		if certificatePartnerFields[name] {
+			return nil, fmt.Errorf("Certificate field %s belongs to the partner, use updatePartner", name)
+		}
+
+		if name == "Participant" {
+			// the only field holding a list
+			participantsAsBytes, _ := json.Marshal(value)
+			participants := Participants{}
+			if err = json.Unmarshal(participantsAsBytes, &participants); err != nil {
+				return nil, err
+			}
+			if err = validateParticipants(participants); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var text string
+		if value != nil {
+			var ok bool
+			if text, ok = value.(string); !ok {
+				return nil, fmt.Errorf("Certificate field %s must be a string or null", name)
+			}
+		}
+		if validator, ok := certificateFieldValidators[name]; ok {
+			if err = validator(text); err != nil {
+				return nil, fmt.Errorf("Invalid value for %s: %s", name, err)
+			}
+		}
+	}
+
+	patchedAsBytes, err := json.Marshal(mergePatch(document, patch))
+	if err != nil {
+		return nil, err
+	}
+	patched := &Certificate{}
+	if err = json.Unmarshal(patchedAsBytes, patched); err != nil {
+		return nil, err
+	}
+	return patched, nil
+}
+
+// mergePatch merges patch into target following RFC 7396
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergePatch(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
+
+// diffCertificateFields returns the sorted names of the fields that differ
+// between previous and certificate
+func diffCertificateFields(previous *Certificate, certificate *Certificate) ([]string, error) {
+	before, err := certificateToMap(previous)
+	if err != nil {
+		return nil, err
+	}
+	after, err := certificateToMap(certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	for name := range certificateFieldNames() {
+		if name == "ChangedFields" || name == "Issuer" || name == "LastTxID" {
+			continue
+		}
+		if !reflect.DeepEqual(before[name], after[name]) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// certificateToMap converts a Certificate to its generic JSON form
+func certificateToMap(certificate *Certificate) (map[string]interface{}, error) {
+	certificateAsBytes, err := json.Marshal(certificate)
+	if err != nil {
+		return nil, err
+	}
+	document := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(certificateAsBytes))
+	decoder.UseNumber()
+	if err = decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+// certificateFieldNames returns the JSON field names of a Certificate
+func certificateFieldNames() map[string]bool {
+	names := map[string]bool{}
+	certificateType := reflect.TypeOf(Certificate{})
+	for i := 0; i < certificateType.NumField(); i++ {
+		field := certificateType.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		names[name] = true
+	}
+	return names
+}
+
+// validateCertificate validates every field of certificate
+func validateCertificate(certificate *Certificate) error {
+	document, err := certificateToMap(certificate)
+	if err != nil {
+		return err
+	}
+	for name, validator := range certificateFieldValidators {
+		value, _ := document[name].(string)
+		if err = validator(value); err != nil {
+			return fmt.Errorf("Invalid value for %s: %s", name, err)
+		}
+	}
+	return validateParticipants(certificate.Participant)
+}
+
+func validateRequired(value string) error {
+	if len(value) <= 0 {
+		return fmt.Errorf("must be a non-empty string")
+	}
+	return nil
+}
+
+func validateMobile(value string) error {
+	for _, c := range value {
+		if (c < '0' || c > '9') && c != '+' && c != '-' && c != ' ' {
+			return fmt.Errorf("must only contain digits, '+', '-' and spaces")
+		}
+	}
+	return nil
+}
+
+func validateEmail(value string) error {
+	if value == "" {
+		return nil
+	}
+	if _, err := mail.ParseAddress(value); err != nil {
+		return fmt.Errorf("must be an e-mail address")
+	}
+	return nil
+}
+
+func validateDate(value string) error {
+	if value == "" {
+		return nil
+	}
+	if _, err := parseCertificateDate(value); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateCertificateStatus(value string) error {
+	if _, ok := CertificateStatusMap[value]; !ok {
+		return fmt.Errorf("must be one of 0, 1, 2 or 3")
+	}
+	return nil
+}
+
+func validateScore(value string) error {
+	if value == "" {
+		return nil
+	}
+	score, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(score) || math.IsInf(score, 0) {
+		return fmt.Errorf("must be a number")
+	}
+	if score < MinScore || score > MaxScore {
+		return fmt.Errorf("must be from %g to %g", MinScore, MaxScore)
+	}
+	return nil
+}
+
+// parseCertificateDate parses PassingDate or ExpiryDate
+func parseCertificateDate(value string) (time.Time, error) {
+	for _, layout := range certificateDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("must be a date formatted as YYYY-MM-DD")
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ===============================================================================
+// renewCertificate - issue the renewal of a certificate and supersede the old
+// one. Limited to the organization that issued the old one and admins.
+// args: old CertificateHash, new Certificate JSON [, signature]
+// ===============================================================================
+func (s *SmartContract) renewCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+
+	oldAsBytes, err := stub.GetState(args[0])
+	if err != nil {
+		return shim.Error("Failed to get Certificate record: " + err.Error())
+	} else if oldAsBytes == nil {
+		return shim.Error("Certificate does not exist: " + args[0])
+	}
+
+	previous := Certificate{}
+	if err = json.Unmarshal(oldAsBytes, &previous); err != nil {
+		return shim.Error(err.Error())
+	}
+	if previous.Revocation != nil {
+		return shim.Error("Certificate is revoked: " + args[0])
+	}
+	if previous.SupersededBy != "" {
+		return shim.Error(fmt.Sprintf("Certificate %s is already superseded by %s", args[0], previous.SupersededBy))
+	}
+	if err = authorizeIssuerOrAdmin(stub, &previous, "renew"); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	certificate, err := certificateFromJSON(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if certificate.CertificateHash == previous.CertificateHash {
+		return shim.Error("A renewal must have a new CertificateHash")
+	}
+
+	// link both certificates to the registry so their partners can be compared
+	cache := partnerCache{}
+	batch := newCertificateBatch()
+	superseded := previous
+	if err = linkPartner(stub, &superseded, cache); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = checkPartnerContacts(stub, certificate, cache); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = linkPartner(stub, certificate, cache); err != nil {
+		return shim.Error(err.Error())
+	}
+	if certificate.PartnerID != superseded.PartnerID || certificate.CertificateName != previous.CertificateName {
+		return shim.Error("A renewal must keep the partner and CertificateName of the renewed certificate")
+	}
+
+	signature := ""
+	if len(args) == 3 {
+		signature = args[2]
+	}
+	certificate.Supersedes = previous.CertificateHash
+	if err = issueCertificate(stub, certificate, signature, batch); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	superseded.SupersededBy = certificate.CertificateHash
+	if superseded.ChangedFields, err = diffCertificateFields(&previous, &superseded); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = storeCertificate(stub, &previous, &superseded, batch); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = batch.emit(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("- renewCertificate %s superseded by %s\n", previous.CertificateHash, certificate.CertificateHash)
+	return shim.Success(nil)
+}
+
+// ===============================================================================
+// queryCertificateLineage - return the renewal chains of a certificate
+// args: PartnerName, CertificateName
+// Every chain is ordered from the first issued certificate to the latest renewal.
+// ===============================================================================
+func (s *SmartContract) queryCertificateLineage(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	// certificates linked to the registered partner and those not migrated yet
+	queries := [][]string{{CerfificationQueryMap["PartnerName"], args[0], args[1]}}
+	partner, err := getPartnerByName(stub, args[0], nil)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if partner != nil {
+		queries = append(queries, []string{PartnerIDIndexName, partner.PartnerID, args[1]})
+	}
+
+	certificates := map[string]*Certificate{}
+	var hashes []string
+	for _, query := range queries {
+		resultsIterator, err := stub.GetStateByPartialCompositeKey(query[0], query[1:])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		for resultsIterator.HasNext() {
+			responseRange, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return shim.Error(err.Error())
+			}
+			_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+			if err != nil {
+				resultsIterator.Close()
+				return shim.Error(err.Error())
+			}
+
+			certificate, err := getCertificate(stub, compositeKeyParts[2])
+			if err != nil {
+				resultsIterator.Close()
+				return shim.Error(err.Error())
+			}
+			if certificate == nil || certificates[certificate.CertificateHash] != nil {
+				continue
+			}
+			if err = resolvePartner(stub, certificate, nil); err != nil {
+				resultsIterator.Close()
+				return shim.Error(err.Error())
+			}
+			certificates[certificate.CertificateHash] = certificate
+			hashes = append(hashes, certificate.CertificateHash)
+		}
+		resultsIterator.Close()
+	}
+
+	if !canSeePersonalData(stub) {
+		for _, certificate := range certificates {
+			maskCertificate(certificate)
+		}
+	}
+
+	// walk every chain from its root, i.e. a certificate that renews nothing we know of
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+	bArrayMemberAlreadyWritten := false
+	for _, hash := range hashes {
+		if _, renewsKnown := certificates[certificates[hash].Supersedes]; renewsKnown {
+			continue
+		}
+
+		var chain []*Certificate
+		visited := map[string]bool{}
+		for certificate := certificates[hash]; certificate != nil && !visited[certificate.CertificateHash]; certificate = certificates[certificate.SupersededBy] {
+			visited[certificate.CertificateHash] = true
+			chain = append(chain, certificate)
+		}
+
+		chainAsBytes, err := json.Marshal(chain)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if bArrayMemberAlreadyWritten == true {
+			buffer.WriteString(",")
+		}
+		buffer.Write(chainAsBytes)
+		bArrayMemberAlreadyWritten = true
+	}
+	buffer.WriteString("]")
+
+	fmt.Printf("- queryCertificateLineage returning:\n%s\n", buffer.String())
+	return shim.Success(buffer.Bytes())
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// RevocationIndexName indexes revoked certificates by revocation time
+const RevocationIndexName = "revoked~all"
+
+// RevokedStatus is the CertificateStatus of a revoked certificate
+const RevokedStatus = "3"
+
+// RevocationSyncOverlap is subtracted from the since argument of
+// queryRevokedSince. RevokedAt is the transaction timestamp set by the
+// client, so a revocation committed after another one may carry an earlier
+// time. The overlap lets an incremental sync from the last NextSince catch
+// such revocations, at the cost of returning some entries again.
+const RevocationSyncOverlap = 10 * time.Minute
+
+// Revocation records why and when a certificate was revoked
+type Revocation struct {
+	Reason         string `json:"Reason"`
+	EffectiveDate  string `json:"EffectiveDate"`
+	RevokedAt      string `json:"RevokedAt"` // RFC 3339 transaction timestamp, in UTC
+	RevokedBy      string `json:"RevokedBy"` // MSP ID of the invoker
+	PreviousStatus string `json:"PreviousStatus"`
+}
+
+// RevocationEntry is a single entry of the list returned by queryRevokedSince
+type RevocationEntry struct {
+	CertificateHash string `json:"CertificateHash"`
+	Reason          string `json:"Reason"`
+	EffectiveDate   string `json:"EffectiveDate"`
+	RevokedAt       string `json:"RevokedAt"`
+}
+
+// RevocationList is the CRL-style response of queryRevokedSince. Clients
+// pass NextSince to the following call to sync incrementally.
+type RevocationList struct {
+	Since     string            `json:"Since"`
+	NextSince string            `json:"NextSince"`
+	Entries   []RevocationEntry `json:"Entries"`
+}
+
+// ===============================================================================
+// revokeCertificate - revoke a certificate while keeping its record. Limited
+// to the issuing organization and admins.
+// args: CertificateHash, reason, effectiveDate
+// ===============================================================================
+func (s *SmartContract) revokeCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if len(args[1]) <= 0 {
+		return shim.Error("2nd argument reason must be a non-empty string")
+	}
+	if err := validateDate(args[2]); err != nil {
+		return shim.Error("3rd argument effectiveDate " + err.Error())
+	}
+
+	previous, err := getCertificate(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if previous == nil {
+		return shim.Error("Certificate does not exist: " + args[0])
+	}
+	if previous.Revocation != nil {
+		return shim.Error("Certificate is already revoked: " + args[0])
+	}
+	if err = authorizeIssuerOrAdmin(stub, previous, "revoke"); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	revokedAt, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	effectiveDate := args[2]
+	if effectiveDate == "" {
+		effectiveDate = revokedAt.Format("2006-01-02")
+	}
+
+	certificate := *previous
+	if err = linkPartner(stub, &certificate, nil); err != nil {
+		return shim.Error(err.Error())
+	}
+	certificate.CertificateStatus = RevokedStatus
+	certificate.Revocation = &Revocation{
+		Reason:         args[1],
+		EffectiveDate:  effectiveDate,
+		RevokedAt:      revokedAt.Format(time.RFC3339),
+		RevokedBy:      mspID,
+		PreviousStatus: previous.CertificateStatus,
+	}
+	certificate.ChangedFields, err = diffCertificateFields(previous, &certificate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err = storeCertificate(stub, previous, &certificate, nil); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("- revokeCertificate %s at %s\n", args[0], certificate.Revocation.RevokedAt)
+	return shim.Success(nil)
+}
+
+// ===============================================================================
+// queryRevokedSince - list certificates revoked at or after a timestamp, less
+// RevocationSyncOverlap. Entries already seen in an earlier sync are returned
+// again, so deduplicate them by CertificateHash. A revocation stamped more
+// than the overlap before the revocations committed around it can still be
+// missed; run a full sync with an empty timestamp from time to time.
+// args: RFC 3339 timestamp, or an empty string for the full list
+// ===============================================================================
+func (s *SmartContract) queryRevokedSince(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var since, next time.Time
+	if args[0] != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, args[0]); err != nil {
+			return shim.Error("1st argument must be an RFC 3339 timestamp")
+		}
+		next = since
+		since = since.Add(-RevocationSyncOverlap)
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(RevocationIndexName, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	list := RevocationList{Since: args[0], NextSince: args[0], Entries: []RevocationEntry{}}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		revokedAt, err := time.Parse(time.RFC3339, compositeKeyParts[0])
+		if err != nil || revokedAt.Before(since) {
+			continue
+		}
+
+		certificate, err := getCertificate(stub, compositeKeyParts[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if certificate == nil || certificate.Revocation == nil {
+			continue
+		}
+
+		list.Entries = append(list.Entries, RevocationEntry{
+			CertificateHash: certificate.CertificateHash,
+			Reason:          certificate.Revocation.Reason,
+			EffectiveDate:   certificate.Revocation.EffectiveDate,
+			RevokedAt:       certificate.Revocation.RevokedAt,
+		})
+		// entries from the overlap do not move NextSince back
+		if revokedAt.After(next) {
+			next = revokedAt
+			list.NextSince = certificate.Revocation.RevokedAt
+		}
+	}
+
+	listAsBytes, err := json.Marshal(list)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- queryRevokedSince returning %d entries\n", len(list.Entries))
+	return shim.Success(listAsBytes)
+}
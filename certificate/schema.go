@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// CertificateSchemaVersion is the version of the Certificate records written
+// by this chaincode. Version 1 records were written before versioning, have
+// no SchemaVersion field and carry their own copy of the partner data. In
+// version 2 the partner data lives in the partner registry. Version 3 turns
+// Participant from a single name into a list of participants. Version 4 adds
+// the PassingDate and ExpiryDate indexes and leaves the record unchanged.
+// The layout is documented in schema/certificate.schema.json, the changes
+// between versions in schema/README.md.
+const CertificateSchemaVersion = 4
+
+// certificateSchemaVersion returns the schema version of a stored record
+func certificateSchemaVersion(certificate *Certificate) int {
+	if certificate.SchemaVersion == 0 {
+		return 1
+	}
+	return certificate.SchemaVersion
+}
+
+// ===============================================================================
+// migrateCertificates - rewrite certificate records to the current schema
+// version. Admin only.
+// args: batch size [, start key]
+// Returns the number of migrated certificates and the key to resume from,
+// which is empty once every certificate has been visited.
+// ===============================================================================
+func (s *SmartContract) migrateCertificates(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
+	}
+	if !isAdmin(stub) {
+		return shim.Error("Only an admin can migrate certificates")
+	}
+
+	batchSize, err := strconv.Atoi(args[0])
+	if err != nil || batchSize <= 0 {
+		return shim.Error("1st argument batch size must be a positive number")
+	}
+	startKey := ""
+	if len(args) == 2 {
+		startKey = args[1]
+	}
+
+	// paginated queries are limited to read-only transactions, so the batch
+	// is cut from a plain range query
+	resultsIterator, err := stub.GetStateByRange(startKey, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	cache := partnerCache{}
+	batch := newCertificateBatch()
+	result := struct {
+		Migrated int    `json:"Migrated"`
+		Next     string `json:"Next"`
+	}{}
+
+	for visited := 0; resultsIterator.HasNext(); visited++ {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if visited == batchSize {
+			result.Next = queryResponse.Key
+			break
+		}
+
+		previous := Certificate{}
+		if err = json.Unmarshal(queryResponse.Value, &previous); err != nil || previous.CertificateHash != queryResponse.Key {
+			// not a certificate record
+			continue
+		}
+		if certificateSchemaVersion(&previous) >= CertificateSchemaVersion {
+			continue
+		}
+
+		certificate := previous
+		if err = upgradeCertificate(stub, &certificate, cache); err != nil {
+			return shim.Error(fmt.Sprintf("Failed to migrate %s: %s", queryResponse.Key, err))
+		}
+		if certificate.ChangedFields, err = diffCertificateFields(&previous, &certificate); err != nil {
+			return shim.Error(err.Error())
+		}
+		if err = storeCertificate(stub, &previous, &certificate, batch); err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Migrated++
+	}
+
+	if err = batch.emit(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- migrateCertificates: %s\n", resultAsBytes)
+	return shim.Success(resultAsBytes)
+}
+
+// upgradeCertificate applies the migration steps from the schema version of
+// certificate up to CertificateSchemaVersion
+func upgradeCertificate(stub shim.ChaincodeStubInterface, certificate *Certificate, cache partnerCache) error {
+	version := certificateSchemaVersion(certificate)
+	if version < 2 {
+		// move the partner data to the registry
+		if err := linkPartner(stub, certificate, cache); err != nil {
+			return err
+		}
+	}
+	// versions 3 and 4 need no rewrite: a legacy Participant name is read as
+	// a list holding that participant, and storing the record creates the
+	// date indexes
+	certificate.SchemaVersion = CertificateSchemaVersion
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// DefaultSocialSecurityChaincode is the name of the SocialSecurity chaincode
+// unless Init names another one
+const DefaultSocialSecurityChaincode = "socialsecurity"
+
+// SocialSecurityIndexName indexes certificates by their linked
+// social-security certificate ID
+const SocialSecurityIndexName = "socialsecurity~cert"
+
+// SocialSecurityLink records the check of the linked social-security
+// certificate made when the certificate was issued
+type SocialSecurityLink struct {
+	Status    string `json:"Status"`   // effective status reported by the SocialSecurity chaincode
+	LinkedAt  string `json:"LinkedAt"` // RFC 3339 transaction timestamp
+	Chaincode string `json:"Chaincode"`
+	Channel   string `json:"Channel,omitempty"` // empty for the channel of the certificate
+}
+
+// SocialSecurityStatus is the checkCertificateStatus response of the
+// SocialSecurity chaincode
+type SocialSecurityStatus struct {
+	CertificateID string `json:"CertificateID"`
+	Exists        bool   `json:"Exists"`
+	Status        string `json:"Status"`
+	Valid         bool   `json:"Valid"`
+}
+
+// socialSecurityConfig locates the SocialSecurity chaincode
+type socialSecurityConfig struct {
+	Chaincode string `json:"Chaincode"`
+	Channel   string `json:"Channel,omitempty"`
+}
+
+// RevokedSocialSecurityLink is a certificate whose linked social-security
+// certificate is no longer active
+type RevokedSocialSecurityLink struct {
+	CertificateHash  string `json:"CertificateHash"`
+	SocialSecurityID string `json:"SocialSecurityID"`
+	LinkedStatus     string `json:"LinkedStatus"`
+	Status           string `json:"Status"` // revoked, or removed if the record no longer exists
+}
+
+// ===============================================================================
+// queryRevokedSocialSecurityLinks - the certificates whose linked
+// social-security certificate has been revoked or removed since it was linked
+// ===============================================================================
+func (s *SmartContract) queryRevokedSocialSecurityLinks(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(SocialSecurityIndexName, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	// the index is sorted by social-security ID, each is checked once
+	statuses := map[string]*SocialSecurityStatus{}
+	links := []RevokedSocialSecurityLink{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		socialSecurityID, certificateHash := compositeKeyParts[0], compositeKeyParts[1]
+
+		status, ok := statuses[socialSecurityID]
+		if !ok {
+			if status, err = checkSocialSecurity(stub, socialSecurityID); err != nil {
+				return shim.Error(err.Error())
+			}
+			statuses[socialSecurityID] = status
+		}
+		link := RevokedSocialSecurityLink{CertificateHash: certificateHash, SocialSecurityID: socialSecurityID, Status: status.Status}
+		if !status.Exists {
+			link.Status = "removed"
+		} else if status.Status != "revoked" {
+			continue
+		}
+
+		certificateAsBytes, err := stub.GetState(certificateHash)
+		if err != nil {
+			return shim.Error("Failed to get Certificate record: " + err.Error())
+		}
+		certificate := Certificate{}
+		if certificateAsBytes != nil && json.Unmarshal(certificateAsBytes, &certificate) == nil && certificate.SocialSecurityLink != nil {
+			link.LinkedStatus = certificate.SocialSecurityLink.Status
+		}
+		links = append(links, link)
+	}
+
+	linksAsBytes, err := json.Marshal(links)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(linksAsBytes)
+}
+
+// linkSocialSecurity checks that the social-security certificate named by a
+// new certificate exists and is active, and records the check
+func linkSocialSecurity(stub shim.ChaincodeStubInterface, certificate *Certificate) error {
+	status, err := checkSocialSecurity(stub, certificate.SocialSecurityID)
+	if err != nil {
+		return err
+	}
+	if !status.Exists {
+		return fmt.Errorf("Social-security certificate does not exist: %s", certificate.SocialSecurityID)
+	}
+	if !status.Valid {
+		return fmt.Errorf("Social-security certificate %s is %s", certificate.SocialSecurityID, status.Status)
+	}
+
+	config, err := getSocialSecurityConfig(stub)
+	if err != nil {
+		return err
+	}
+	linkedAt, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	certificate.SocialSecurityLink = &SocialSecurityLink{
+		Status:    status.Status,
+		LinkedAt:  linkedAt.Format(time.RFC3339),
+		Chaincode: config.Chaincode,
+		Channel:   config.Channel,
+	}
+	return nil
+}
+
+// checkSocialSecurity asks the SocialSecurity chaincode for the status of a
+// certificate
+func checkSocialSecurity(stub shim.ChaincodeStubInterface, socialSecurityID string) (*SocialSecurityStatus, error) {
+	config, err := getSocialSecurityConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	response := stub.InvokeChaincode(config.Chaincode, [][]byte{[]byte("checkCertificateStatus"), []byte(socialSecurityID)}, config.Channel)
+	if response.Status != shim.OK {
+		return nil, fmt.Errorf("Failed to check social-security certificate %s: %s", socialSecurityID, response.Message)
+	}
+	status := &SocialSecurityStatus{}
+	if err = json.Unmarshal(response.Payload, status); err != nil {
+		return nil, fmt.Errorf("Invalid response of %s: %s", config.Chaincode, err)
+	}
+	return status, nil
+}
+
+// getSocialSecurityConfig reads the location of the SocialSecurity chaincode
+// stored by Init
+func getSocialSecurityConfig(stub shim.ChaincodeStubInterface) (*socialSecurityConfig, error) {
+	configKey, err := stub.CreateCompositeKey("config", []string{"socialsecurity"})
+	if err != nil {
+		return nil, err
+	}
+	configAsBytes, err := stub.GetState(configKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get SocialSecurity chaincode config: %s", err)
+	}
+	config := &socialSecurityConfig{Chaincode: DefaultSocialSecurityChaincode}
+	if configAsBytes != nil {
+		if err = json.Unmarshal(configAsBytes, config); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+func putSocialSecurityConfig(stub shim.ChaincodeStubInterface, config *socialSecurityConfig) error {
+	configKey, err := stub.CreateCompositeKey("config", []string{"socialsecurity"})
+	if err != nil {
+		return err
+	}
+	configAsBytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(configKey, configAsBytes)
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ScoreStatsObjectTypes are the aggregate record key families, one record
+// per CertificateName and one per PartnerID
+var ScoreStatsObjectTypes = map[string]string{
+	"CertificateName": "stats~certificate",
+	"PartnerName":     "stats~partner",
+}
+
+// DefaultScoreBucketWidth is the width of a histogram bucket
+const DefaultScoreBucketWidth = 10
+
+// MaxScoreBuckets limits the size of a histogram
+const MaxScoreBuckets = 1000
+
+// MinScore and MaxScore bound the scores of certificates and participants
+const (
+	MinScore = 0.0
+	MaxScore = 100.0
+)
+
+// passedStatuses are the CertificateStatus codes counted as a pass. A revoked
+// certificate does not count as a pass.
+var passedStatuses = map[string]bool{
+	"0": true,
+	"2": true,
+}
+
+// ScoreAggregate is the on-ledger aggregate of a group of certificates. It is
+// kept up to date by every write of a certificate record, so statistics never
+// need a scan. Scores counts the certificates per numeric score.
+type ScoreAggregate struct {
+	Count  int            `json:"Count"`
+	Passed int            `json:"Passed"`
+	Scores map[string]int `json:"Scores"`
+}
+
+// scoreCache holds the score aggregates written in the current transaction,
+// since GetState does not see them
+type scoreCache map[string]*ScoreAggregate
+
+// ScoreBucket is a histogram bucket holding the scores From <= score < To
+type ScoreBucket struct {
+	From  float64 `json:"From"`
+	To    float64 `json:"To"`
+	Count int     `json:"Count"`
+}
+
+// ScoreStatistics is the response of queryScoreStatistics
+type ScoreStatistics struct {
+	Group     string        `json:"Group"`
+	Name      string        `json:"Name"`
+	Count     int           `json:"Count"`
+	Scored    int           `json:"Scored"` // certificates with a numeric score
+	Mean      float64       `json:"Mean"`
+	Min       float64       `json:"Min"`
+	Max       float64       `json:"Max"`
+	PassRate  float64       `json:"PassRate"`
+	Histogram []ScoreBucket `json:"Histogram"`
+}
+
+// ===============================================================================
+// queryScoreStatistics - score statistics of a CertificateName or PartnerName
+// args: [PartnerName, CertificateName], name [, bucketWidth]
+// ===============================================================================
+func (s *SmartContract) queryScoreStatistics(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+	objectType, ok := ScoreStatsObjectTypes[args[0]]
+	if !ok {
+		return shim.Error("Incorrect Query Option [PartnerName, CertificateName]")
+	}
+	width := float64(DefaultScoreBucketWidth)
+	if len(args) == 3 {
+		var err error
+		if width, err = strconv.ParseFloat(args[2], 64); err != nil || width <= 0 || math.IsInf(width, 0) {
+			return shim.Error("bucketWidth must be a positive number")
+		}
+	}
+
+	// partner aggregates are kept by PartnerID
+	groupKey := args[1]
+	if args[0] == "PartnerName" {
+		partner, err := getPartnerByName(stub, args[1], nil)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		groupKey = ""
+		if partner != nil {
+			groupKey = partner.PartnerID
+		}
+	}
+
+	aggregate := &ScoreAggregate{Scores: map[string]int{}}
+	if groupKey != "" {
+		key, err := stub.CreateCompositeKey(objectType, []string{groupKey})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if aggregate, err = getScoreAggregate(stub, key, nil); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	statistics, err := newScoreStatistics(aggregate, width)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	statistics.Group = args[0]
+	statistics.Name = args[1]
+
+	statisticsAsBytes, err := json.Marshal(statistics)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(statisticsAsBytes)
+}
+
+// ===============================================================================
+// rebuildScoreStatistics - recompute every aggregate record from the
+// certificate records. Run once after upgrading from a version without
+// statistics. Admin only.
+// ===============================================================================
+func (s *SmartContract) rebuildScoreStatistics(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("Only an admin can rebuild the score statistics")
+	}
+
+	// drop the current aggregates
+	for _, objectType := range ScoreStatsObjectTypes {
+		resultsIterator, err := stub.GetStateByPartialCompositeKey(objectType, []string{})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		for resultsIterator.HasNext() {
+			responseRange, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return shim.Error(err.Error())
+			}
+			if err = stub.DelState(responseRange.Key); err != nil {
+				resultsIterator.Close()
+				return shim.Error(err.Error())
+			}
+		}
+		resultsIterator.Close()
+	}
+
+	// GetState does not see the writes of this transaction, so the new
+	// aggregates are built in memory and written at the end
+	aggregates := map[string]*ScoreAggregate{}
+	resultsIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	counted := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		certificate := &Certificate{}
+		if err = json.Unmarshal(queryResponse.Value, certificate); err != nil || certificate.CertificateHash == "" {
+			continue
+		}
+
+		keys, err := scoreAggregateKeys(stub, certificate)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		for _, key := range keys {
+			if aggregates[key] == nil {
+				aggregates[key] = &ScoreAggregate{Scores: map[string]int{}}
+			}
+			aggregates[key].add(certificate, 1)
+		}
+		counted++
+	}
+
+	for key, aggregate := range aggregates {
+		if err = putScoreAggregate(stub, key, aggregate, nil); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	fmt.Printf("- rebuildScoreStatistics counted %d certificates\n", counted)
+	return shim.Success([]byte(strconv.Itoa(counted)))
+}
+
+// updateScoreStatistics moves a certificate from the aggregates of its
+// previous version to those of its new version. previous is nil for a new
+// record and certificate is nil for a removed one.
+func updateScoreStatistics(stub shim.ChaincodeStubInterface, previous *Certificate, certificate *Certificate, cache scoreCache) error {
+	previousKeys, err := scoreAggregateKeys(stub, previous)
+	if err != nil {
+		return err
+	}
+	keys, err := scoreAggregateKeys(stub, certificate)
+	if err != nil {
+		return err
+	}
+
+	deltas := map[string]int{}
+	for _, key := range previousKeys {
+		deltas[key]--
+	}
+	for _, key := range keys {
+		deltas[key]++
+	}
+
+	for key, delta := range deltas {
+		// the certificate stays in this group, skip unless its score or
+		// pass state changed
+		if delta == 0 && scoreOf(previous) == scoreOf(certificate) &&
+			passedStatuses[previous.CertificateStatus] == passedStatuses[certificate.CertificateStatus] {
+			continue
+		}
+
+		aggregate, err := getScoreAggregate(stub, key, cache)
+		if err != nil {
+			return err
+		}
+		if delta <= 0 {
+			aggregate.add(previous, -1)
+		}
+		if delta >= 0 {
+			aggregate.add(certificate, 1)
+		}
+		if err = putScoreAggregate(stub, key, aggregate, cache); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scoreAggregateKeys returns the keys of the aggregates a certificate counts
+// in. Certificates not yet linked to the partner registry only count per
+// CertificateName.
+func scoreAggregateKeys(stub shim.ChaincodeStubInterface, certificate *Certificate) ([]string, error) {
+	if certificate == nil {
+		return nil, nil
+	}
+	keys := []string{}
+	groups := map[string]string{
+		"CertificateName": certificate.CertificateName,
+		"PartnerName":     certificate.PartnerID,
+	}
+	for group, groupKey := range groups {
+		if groupKey == "" {
+			continue
+		}
+		key, err := stub.CreateCompositeKey(ScoreStatsObjectTypes[group], []string{groupKey})
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// add counts a certificate in the aggregate, or removes it when sign is -1
+func (a *ScoreAggregate) add(certificate *Certificate, sign int) {
+	a.Count += sign
+	if passedStatuses[certificate.CertificateStatus] {
+		a.Passed += sign
+	}
+	if score := scoreOf(certificate); score != "" {
+		a.Scores[score] += sign
+		if a.Scores[score] <= 0 {
+			delete(a.Scores, score)
+		}
+	}
+}
+
+// scoreOf returns the normalized score of a certificate, or an empty string
+// when it has no numeric score
+func scoreOf(certificate *Certificate) string {
+	value, err := strconv.ParseFloat(certificate.Score, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return ""
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func getScoreAggregate(stub shim.ChaincodeStubInterface, key string, cache scoreCache) (*ScoreAggregate, error) {
+	if aggregate, ok := cache[key]; ok {
+		return aggregate, nil
+	}
+
+	aggregate := &ScoreAggregate{}
+	aggregateAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get score statistics: %s", err)
+	}
+	if aggregateAsBytes != nil {
+		if err = json.Unmarshal(aggregateAsBytes, aggregate); err != nil {
+			return nil, err
+		}
+	}
+	if aggregate.Scores == nil {
+		aggregate.Scores = map[string]int{}
+	}
+	if cache != nil {
+		cache[key] = aggregate
+	}
+	return aggregate, nil
+}
+
+func putScoreAggregate(stub shim.ChaincodeStubInterface, key string, aggregate *ScoreAggregate, cache scoreCache) error {
+	if cache != nil {
+		cache[key] = aggregate
+	}
+	if aggregate.Count <= 0 {
+		return stub.DelState(key)
+	}
+	aggregateAsBytes, err := json.Marshal(aggregate)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, aggregateAsBytes)
+}
+
+// newScoreStatistics computes the statistics of an aggregate
+func newScoreStatistics(aggregate *ScoreAggregate, width float64) (*ScoreStatistics, error) {
+	statistics := &ScoreStatistics{Count: aggregate.Count, Histogram: []ScoreBucket{}}
+	if aggregate.Count > 0 {
+		statistics.PassRate = float64(aggregate.Passed) / float64(aggregate.Count)
+	}
+
+	type scoreCount struct {
+		value float64
+		count int
+	}
+	scores := []scoreCount{}
+	for score, count := range aggregate.Scores {
+		value, err := strconv.ParseFloat(score, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid score in statistics: %s", score)
+		}
+		scores = append(scores, scoreCount{value, count})
+	}
+	if len(scores) == 0 {
+		return statistics, nil
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i].value < scores[j].value })
+
+	statistics.Min = scores[0].value
+	statistics.Max = scores[len(scores)-1].value
+	first := math.Floor(statistics.Min / width)
+	last := math.Floor(statistics.Max / width)
+	if last-first >= MaxScoreBuckets {
+		return nil, fmt.Errorf("bucketWidth is too small, the histogram would have more than %d buckets", MaxScoreBuckets)
+	}
+
+	sum := 0.0
+	statistics.Histogram = make([]ScoreBucket, int(last-first)+1)
+	for i := range statistics.Histogram {
+		from := (first + float64(i)) * width
+		statistics.Histogram[i] = ScoreBucket{From: from, To: from + width}
+	}
+	for _, score := range scores {
+		statistics.Scored += score.count
+		sum += score.value * float64(score.count)
+		statistics.Histogram[int(math.Floor(score.value/width)-first)].Count += score.count
+	}
+	statistics.Mean = sum / float64(statistics.Scored)
+
+	return statistics, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// PublicVerification is the fixed projection of a certificate returned by
+// publicVerify. It never carries personal data.
+type PublicVerification struct {
+	CertificateHash string `json:"CertificateHash"`
+	CertificateName string `json:"CertificateName"`
+	CertificateType string `json:"CertificateType"`
+	PartnerName     string `json:"PartnerName"`
+	Status          string `json:"Status"`
+	ValidFrom       string `json:"ValidFrom"`
+	ValidUntil      string `json:"ValidUntil"`
+	LastTxID        string `json:"LastTxID"`
+	CheckedAt       string `json:"CheckedAt"`
+}
+
+// ===============================================================================
+// publicVerify - check a certificate given only its hash
+// args: CertificateHash
+// The projection is returned as the response payload, so it is covered by the
+// endorsement signatures of the proposal response. Clients embedding it in a
+// QR code landing page keep the signed proposal response next to it. Every
+// field is derived from the ledger and the transaction timestamp, so all
+// endorsers return the same bytes.
+// ===============================================================================
+func (s *SmartContract) publicVerify(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	certificate, err := getCertificate(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	verification := PublicVerification{CertificateHash: args[0], Status: "not-found", CheckedAt: now.Format(time.RFC3339)}
+	if certificate != nil {
+		if err = resolvePartner(stub, certificate, nil); err != nil {
+			return shim.Error(err.Error())
+		}
+
+		verification.CertificateName = certificate.CertificateName
+		verification.CertificateType = certificate.CertificateType
+		verification.PartnerName = certificate.PartnerName
+		verification.Status = certificateLedgerStatus(certificate, now)
+		verification.ValidFrom = certificate.PassingDate
+		verification.ValidUntil = certificate.ExpiryDate
+		verification.LastTxID = certificate.LastTxID
+		if verification.LastTxID == "" {
+			// written before LastTxID was recorded
+			if verification.LastTxID, err = lastTxID(stub, args[0]); err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+	}
+
+	verificationAsBytes, err := json.Marshal(verification)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("- publicVerify %s: %s\n", args[0], verification.Status)
+	return shim.Success(verificationAsBytes)
+}
+
+// lastTxID returns the ID of the last transaction that wrote key, from the history database
+func lastTxID(stub shim.ChaincodeStubInterface, key string) (string, error) {
+	resultsIterator, err := stub.GetHistoryForKey(key)
+	if err != nil {
+		return "", err
+	}
+	defer resultsIterator.Close()
+
+	txID := ""
+	var latest int64
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return "", err
+		}
+		timestamp := time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).UnixNano()
+		if txID == "" || timestamp >= latest {
+			txID = response.TxId
+			latest = timestamp
+		}
+	}
+	return txID, nil
+}